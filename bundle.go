@@ -2,21 +2,38 @@ package tecgonic
 
 import (
 	"archive/tar"
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
-	"sync/atomic"
+	"strings"
+	"time"
 )
 
 const DefaultBundleURL = "https://relay.fullyjustified.net/default_bundle_v33.tar"
 
 type prepareBundleConfig struct {
-	progress io.Writer
+	progress       ProgressFunc
+	verify         bool
+	expectedDigest string
+	workers        int
+
+	fetcher  BundleFetcher
+	client   *http.Client
+	mirrors  []string
+	attempts int
+	backoff  time.Duration
+
+	fetchTimeout time.Duration
 }
 
 // PrepareBundleOption configures a PrepareBundle call.
@@ -24,48 +41,70 @@ type PrepareBundleOption func(*prepareBundleConfig)
 
 // WithProgress enables progress reporting to the given writer.
 // Download progress (bytes/percentage) and extraction progress (file count) are reported.
+// It replaces any WithProgressFunc; see TextProgress.
 func WithProgress(w io.Writer) PrepareBundleOption {
 	return func(c *prepareBundleConfig) {
-		c.progress = w
-	}
-}
-
-// progressReader wraps an io.Reader and periodically reports bytes read.
-type progressReader struct {
-	r     io.Reader
-	total int64 // from Content-Length, 0 if unknown
-	read  atomic.Int64
-	w     io.Writer
-	last  int64 // last reported byte count
-}
-
-func (pr *progressReader) Read(p []byte) (int, error) {
-	n, err := pr.r.Read(p)
-	if n > 0 {
-		cur := pr.read.Add(int64(n))
-		// Report every 10 MB
-		if cur-pr.last >= 10*1024*1024 {
-			pr.last = cur
-			mb := cur / (1024 * 1024)
-			if pr.total > 0 {
-				totalMB := pr.total / (1024 * 1024)
-				pct := cur * 100 / pr.total
-				_, _ = fmt.Fprintf(pr.w, "  Downloading: %d / %d MB (%d%%)\n", mb, totalMB, pct)
-			} else {
-				_, _ = fmt.Fprintf(pr.w, "  Downloading: %d MB\n", mb)
-			}
-		}
+		c.progress = TextProgress(w)
+	}
+}
+
+// WithExtractWorkers sets how many files are decompressed and written
+// concurrently while extracting a bundle. The default is GOMAXPROCS.
+func WithExtractWorkers(n int) PrepareBundleOption {
+	return func(c *prepareBundleConfig) {
+		c.workers = n
+	}
+}
+
+// WithVerify verifies the bundle with VerifyBundle after extracting it, and
+// verifies an already prepared bundle instead of trusting it. The bundle's
+// content digest must match its SHA256SUM, the digest in a TTB header and,
+// if not empty, expectedDigest.
+func WithVerify(expectedDigest string) PrepareBundleOption {
+	return func(c *prepareBundleConfig) {
+		c.verify = true
+		c.expectedDigest = expectedDigest
+	}
+}
+
+// verifyOptions returns the VerifyBundle options implied by c.
+func (c prepareBundleConfig) verifyOptions() []VerifyOption {
+	if c.expectedDigest == "" {
+		return nil
 	}
-	return n, err
+	return []VerifyOption{WithExpectedDigest(c.expectedDigest)}
 }
 
 // PrepareBundle downloads and extracts a Tectonic TeX Live bundle to destDir.
 //
-// The bundle is an "itar" format: a tar archive where most entries are individually
-// gzip-compressed. Metadata entries (like SVNREV) may not be compressed.
-// Files are extracted to a flat directory structure.
+// Two bundle formats are supported, detected from the downloaded header. The
+// legacy "itar" format is a tar archive where most entries are individually
+// gzip-compressed; metadata entries (like SVNREV) may not be compressed. The
+// newer "ttbv1" format is described at TTB. Files are extracted to a flat
+// directory structure.
 //
-// If destDir already contains SHA256SUM and force is false, the download is skipped.
+// A file:// URL extracts a local bundle file; see also InstallBundle. Other
+// bundles are fetched with the BundleFetcher set by WithFetcher, by default
+// over HTTP, to destDir + ".download" first. A failed download is retried
+// with backoff, moving on to any URLs given with WithMirrors, and resumed
+// where it stopped, including by the next call after an interruption. See
+// WithRetries and RangeFetcher. Once downloaded, the file is removed whether
+// or not the bundle installs, so a bad bundle is downloaded afresh.
+//
+// Extraction starts only once the download is complete, so preparing a
+// bundle needs disk space for both the bundle file and the extracted files,
+// next to each other in destDir's parent; the extracted files take somewhat
+// more space than the compressed bundle.
+//
+// The bundle is extracted into a staging directory next to destDir and moved
+// into place only once complete, so an interrupted run never leaves a partial
+// bundle behind; staging directories of interrupted runs are removed by the
+// next run. A completion marker is written last. If destDir holds a complete
+// bundle and force is false, the download is skipped. A bundle prepared by a
+// version of tecgonic without completion markers cannot be told apart from an
+// interrupted one and is prepared again. A destDir that is not empty and
+// holds no bundle is never replaced. Concurrent calls must not share a
+// destDir.
 // After extraction, call Compiler.GenerateFormat to generate the latex.fmt format file.
 func PrepareBundle(ctx context.Context, destDir, bundleURL string, force bool, opts ...PrepareBundleOption) error {
 	var cfg prepareBundleConfig
@@ -77,45 +116,166 @@ func PrepareBundle(ctx context.Context, destDir, bundleURL string, force bool, o
 		bundleURL = DefaultBundleURL
 	}
 
-	// Check if bundle already extracted (SHA256SUM is always present in the bundle tar)
-	if !force {
-		if _, err := os.Stat(filepath.Join(destDir, "SHA256SUM")); err == nil {
+	// Check if a complete bundle is already installed
+	if !force && bundleComplete(destDir) {
+		if !cfg.verify {
 			return nil
 		}
+		if _, err := VerifyBundle(destDir, cfg.verifyOptions()...); err != nil {
+			return fmt.Errorf("%w (prepare it again with force)", err)
+		}
+		return nil
 	}
 
-	if err := os.MkdirAll(destDir, 0o755); err != nil {
-		return fmt.Errorf("tecgonic: creating bundle dir: %w", err)
+	// A local bundle file is extracted in place
+	if path, ok := fileURLPath(bundleURL); ok {
+		return installBundle(destDir, cfg, func(staging string) (int, bundleManifest, error) {
+			return extractBundleFile(path, staging, cfg)
+		})
+	}
+
+	if err := checkReplaceable(destDir); err != nil {
+		return err
+	}
+
+	// Download to a file kept across runs, so an interrupted download resumes
+	download := destDir + downloadSuffix
+	if err := downloadResumable(ctx, append([]string{bundleURL}, cfg.mirrors...), download, cfg); err != nil {
+		return err
+	}
+	err := installBundle(destDir, cfg, func(staging string) (int, bundleManifest, error) {
+		return extractBundleFile(download, staging, cfg)
+	})
+	// The download is complete: it is either installed or bad, and is not
+	// resumed either way
+	_ = os.Remove(download)
+	return err
+}
+
+// InstallBundle extracts the itar or TTB bundle read from r to destDir, with
+// the same validation as PrepareBundle, e.g. on hosts without network access.
+// Any bundle already in destDir is replaced, but not other files. A TTB
+// bundle is copied to a temporary file next to destDir first, since its index
+// is at the end.
+func InstallBundle(ctx context.Context, destDir string, r io.Reader, opts ...PrepareBundleOption) error {
+	var cfg prepareBundleConfig
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	pr := newProgressReader(&contextReader{ctx: ctx, r: r}, PhaseRead, 0, -1, cfg.progress)
+	br := bufio.NewReader(pr)
+	header, _ := br.Peek(len(ttbSignature))
+
+	return installBundle(destDir, cfg, func(staging string) (int, bundleManifest, error) {
+		if !isTTB(header) {
+			manifest := make(bundleManifest)
+			files, err := extractITar(br, staging, cfg.progress, manifest)
+			if err == nil {
+				cfg.progress.report(pr.event(true))
+			}
+			return files, manifest, err
+		}
+		tmp, err := os.CreateTemp(filepath.Dir(destDir), filepath.Base(destDir)+stagingSuffix+"*.ttb")
+		if err != nil {
+			return 0, nil, fmt.Errorf("tecgonic: creating temporary bundle file: %w", err)
+		}
+		defer func() { _ = os.Remove(tmp.Name()) }()
+		_, err = io.Copy(tmp, br)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return 0, nil, fmt.Errorf("tecgonic: reading bundle: %w", err)
+		}
+		cfg.progress.report(pr.event(true))
+		return extractBundleFile(tmp.Name(), staging, cfg)
+	})
+}
+
+// installBundle extracts a bundle with extract into a staging directory next
+// to destDir, validates it and moves it into place.
+func installBundle(destDir string, cfg prepareBundleConfig, extract func(staging string) (int, bundleManifest, error)) error {
+	if err := checkReplaceable(destDir); err != nil {
+		return err
 	}
 
-	// Download the bundle
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, bundleURL, nil)
+	// Extract into a staging directory next to destDir, removing any left
+	// behind by an interrupted run
+	removeStaleStaging(destDir)
+	if err := os.MkdirAll(filepath.Dir(destDir), 0o755); err != nil {
+		return fmt.Errorf("tecgonic: creating bundle dir: %w", err)
+	}
+	staging, err := os.MkdirTemp(filepath.Dir(destDir), filepath.Base(destDir)+stagingSuffix+"*")
 	if err != nil {
-		return fmt.Errorf("tecgonic: creating request: %w", err)
+		return fmt.Errorf("tecgonic: creating staging dir: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(staging) }()
+	if err := os.Chmod(staging, 0o755); err != nil {
+		return fmt.Errorf("tecgonic: creating staging dir: %w", err)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	files, manifest, err := extract(staging)
 	if err != nil {
-		return fmt.Errorf("tecgonic: downloading bundle: %w", err)
+		return err
 	}
-	defer func() { _ = resp.Body.Close() }()
+	if err := os.WriteFile(filepath.Join(staging, manifestName), manifest.marshal(), 0o644); err != nil {
+		return fmt.Errorf("tecgonic: writing bundle manifest: %w", err)
+	}
+
+	cfg.progress.report(ProgressEvent{Phase: PhaseExtract, Files: files, TotalFiles: files, Done: true})
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("tecgonic: downloading bundle: HTTP %d", resp.StatusCode)
+	// Validate that extraction produced files (check for a common TeX file)
+	entries, err := os.ReadDir(staging)
+	if err != nil {
+		return fmt.Errorf("tecgonic: reading bundle dir: %w", err)
+	}
+	if len(entries) < 100 {
+		return fmt.Errorf("tecgonic: bundle extraction incomplete: only %d files extracted", len(entries))
 	}
 
-	// Wrap body with progress reader if progress reporting is enabled
-	var body io.Reader = resp.Body
-	if cfg.progress != nil {
-		body = &progressReader{
-			r:     resp.Body,
-			total: resp.ContentLength,
-			w:     cfg.progress,
+	if cfg.verify {
+		cfg.progress.report(ProgressEvent{Phase: PhaseVerify})
+		if _, err := VerifyBundle(staging, cfg.verifyOptions()...); err != nil {
+			return err
 		}
+		cfg.progress.report(ProgressEvent{Phase: PhaseVerify, Done: true})
 	}
 
-	// Extract the tar archive
-	tr := tar.NewReader(body)
+	// Mark the bundle complete last, then move it into place
+	if err := os.WriteFile(filepath.Join(staging, completeMarker), nil, 0o644); err != nil {
+		return fmt.Errorf("tecgonic: marking bundle complete: %w", err)
+	}
+	return installDir(staging, destDir)
+}
+
+// fileURLPath returns the local path of a file:// URL.
+func fileURLPath(rawURL string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "file" || (u.Host != "" && u.Host != "localhost") {
+		return "", false
+	}
+	return filepath.FromSlash(u.Path), true
+}
+
+// contextReader fails reads once its context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// extractITar extracts an itar bundle read from r into destDir and returns
+// the number of files written. Entries are decompressed as they are read, one
+// at a time; extractBundleFile extracts a bundle file in parallel instead.
+func extractITar(r io.Reader, destDir string, progress ProgressFunc, manifest bundleManifest) (int, error) {
+	tr := tar.NewReader(r)
 	files := 0
 	for {
 		header, err := tr.Next()
@@ -123,7 +283,7 @@ func PrepareBundle(ctx context.Context, destDir, bundleURL string, force bool, o
 			break
 		}
 		if err != nil {
-			return fmt.Errorf("tecgonic: reading tar entry: %w", err)
+			return files, fmt.Errorf("tecgonic: reading tar entry: %w", err)
 		}
 
 		if header.Typeflag != tar.TypeReg {
@@ -131,55 +291,241 @@ func PrepareBundle(ctx context.Context, destDir, bundleURL string, force bool, o
 		}
 
 		name := filepath.Base(header.Name)
-		destPath := filepath.Join(destDir, name)
-
-		// Read the full entry into memory so we can attempt gzip decompression
-		entryData, err := io.ReadAll(tr)
+		if _, dup := manifest[name]; dup {
+			continue
+		}
+		sum, err := extractEntry(tr, filepath.Join(destDir, name))
 		if err != nil {
-			return fmt.Errorf("tecgonic: reading entry %s: %w", name, err)
+			return files, fmt.Errorf("tecgonic: extracting %s: %w", name, err)
 		}
+		manifest[name] = sum
+
+		files++
+		progress.report(ProgressEvent{Phase: PhaseExtract, Files: files, TotalFiles: -1, Entry: name})
+	}
 
-		// Try gzip decompression; fall back to raw content for metadata entries
-		var reader io.Reader
-		gr, gzErr := gzip.NewReader(bytes.NewReader(entryData))
-		if gzErr == nil {
-			reader = gr
-		} else {
-			reader = bytes.NewReader(entryData)
+	return files, nil
+}
+
+// extractBundleFile extracts the itar or TTB bundle at path into destDir and
+// returns the number of files written and their manifest.
+func extractBundleFile(path, destDir string, cfg prepareBundleConfig) (int, bundleManifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, nil, fmt.Errorf("tecgonic: opening downloaded bundle: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	manifest := make(bundleManifest)
+	header := make([]byte, 512)
+	n, _ := io.ReadFull(f, header)
+	if isTTB(header[:n]) {
+		files, err := extractTTB(f, destDir, cfg, manifest)
+		return files, manifest, err
+	}
+	entries, err := scanITar(f)
+	if err != nil {
+		return 0, nil, fmt.Errorf("tecgonic: reading tar entry: %w", err)
+	}
+	files, err := extractArchive(&archive{f: f, path: path, entries: entries}, destDir, cfg.workers, cfg.progress, manifest)
+	if err != nil {
+		return files, nil, fmt.Errorf("tecgonic: %w", err)
+	}
+	return files, manifest, nil
+}
+
+// extractTTB extracts the TTB bundle in f into destDir and returns the number
+// of files written. If the bundle has no SHA256SUM file, one is written
+// holding the digest from its header.
+func extractTTB(f *os.File, destDir string, cfg prepareBundleConfig, manifest bundleManifest) (int, error) {
+	b, err := newTTB(f, f.Name())
+	if err != nil {
+		return 0, fmt.Errorf("tecgonic: reading TTB bundle: %w", err)
+	}
+	files, err := extractArchive(&b.archive, destDir, cfg.workers, cfg.progress, manifest)
+	if err != nil {
+		return files, fmt.Errorf("tecgonic: %w", err)
+	}
+	if digest := manifest.digest(); cfg.verify && digest != b.Digest() {
+		return files, fmt.Errorf("tecgonic: content digest %s does not match TTB header digest %s: %w", digest, b.Digest(), ErrBundleCorrupt)
+	}
+	if _, ok := b.entries["SHA256SUM"]; !ok {
+		sum := []byte(b.Digest() + "\n")
+		if err := os.WriteFile(filepath.Join(destDir, "SHA256SUM"), sum, 0o644); err != nil {
+			return files, fmt.Errorf("tecgonic: writing SHA256SUM: %w", err)
 		}
+		manifest.add("SHA256SUM", sum)
+	}
+	return files, nil
+}
 
-		if err := writeFile(destPath, reader); err != nil {
-			if gr != nil {
-				_ = gr.Close()
-			}
-			return fmt.Errorf("tecgonic: writing %s: %w", name, err)
+// DownloadBundle downloads the bundle at bundleURL to path without
+// extracting it, for use with OpenBundleArchive, OpenITar or OpenTTB. The
+// bundle is written to path + ".partial", which later calls resume if the
+// download is interrupted, and renamed into place once complete. A file://
+// URL copies a local bundle file.
+//
+// If path already exists and force is false, the download is skipped.
+func DownloadBundle(ctx context.Context, path, bundleURL string, force bool, opts ...PrepareBundleOption) error {
+	var cfg prepareBundleConfig
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	if bundleURL == "" {
+		bundleURL = DefaultBundleURL
+	}
+
+	if !force {
+		if _, err := os.Stat(path); err == nil {
+			return nil
 		}
-		if gr != nil {
-			_ = gr.Close()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("tecgonic: creating bundle dir: %w", err)
+	}
+
+	tmp := path + ".partial"
+	if src, ok := fileURLPath(bundleURL); ok {
+		return copyBundleFile(src, tmp, path)
+	}
+	if err := downloadResumable(ctx, append([]string{bundleURL}, cfg.mirrors...), tmp, cfg); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("tecgonic: saving bundle: %w", err)
+	}
+	return nil
+}
+
+const (
+	// completeMarker is written into a bundle directory once it is complete.
+	completeMarker = ".tecgonic-complete"
+
+	// stagingSuffix and replacedSuffix name the directories, next to a
+	// bundle directory, that a bundle is extracted into and that a replaced
+	// bundle is moved to before removal.
+	stagingSuffix  = ".staging-"
+	replacedSuffix = ".replaced-"
+
+	// downloadSuffix names the file, next to a bundle directory, that the
+	// bundle is downloaded to.
+	downloadSuffix = ".download"
+)
+
+// bundleComplete reports whether dir holds a completely installed bundle.
+func bundleComplete(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, completeMarker))
+	return err == nil
+}
+
+// checkReplaceable returns an error if destDir exists and is neither empty
+// nor a bundle directory, so a wrong path never wipes unrelated files. A
+// directory holding SHA256SUM is taken for a bundle from an older version.
+func checkReplaceable(destDir string) error {
+	entries, err := os.ReadDir(destDir)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil
+	case err != nil:
+		return fmt.Errorf("tecgonic: reading bundle dir: %w", err)
+	case len(entries) == 0:
+		return nil
+	}
+	for _, e := range entries {
+		switch e.Name() {
+		case completeMarker, manifestName, "SHA256SUM":
+			return nil
 		}
+	}
+	return fmt.Errorf("tecgonic: %s is not empty and holds no bundle; refusing to replace it", destDir)
+}
 
-		files++
-		if cfg.progress != nil && files%10000 == 0 {
-			_, _ = fmt.Fprintf(cfg.progress, "  Extracted %d files\n", files)
+// removeStaleStaging removes the staging and replaced directories left next
+// to destDir by interrupted runs.
+func removeStaleStaging(destDir string) {
+	entries, err := os.ReadDir(filepath.Dir(destDir))
+	if err != nil {
+		return
+	}
+	base := filepath.Base(destDir)
+	for _, e := range entries {
+		for _, suffix := range []string{stagingSuffix, replacedSuffix} {
+			if strings.HasPrefix(e.Name(), base+suffix) {
+				_ = os.RemoveAll(filepath.Join(filepath.Dir(destDir), e.Name()))
+			}
 		}
 	}
+}
 
-	if cfg.progress != nil {
-		_, _ = fmt.Fprintf(cfg.progress, "  Extracted %d files (done)\n", files)
+// installDir moves the directory staging to destDir, replacing any existing
+// destDir.
+func installDir(staging, destDir string) error {
+	if err := os.Rename(staging, destDir); err == nil {
+		return nil
+	}
+	if _, err := os.Stat(destDir); err != nil {
+		return fmt.Errorf("tecgonic: installing bundle: %w", err)
 	}
 
-	// Validate that extraction produced files (check for a common TeX file)
-	entries, err := os.ReadDir(destDir)
+	// Move the existing directory aside, then swap the new one in
+	replaced, err := os.MkdirTemp(filepath.Dir(destDir), filepath.Base(destDir)+replacedSuffix+"*")
 	if err != nil {
-		return fmt.Errorf("tecgonic: reading bundle dir: %w", err)
+		return fmt.Errorf("tecgonic: installing bundle: %w", err)
 	}
-	if len(entries) < 100 {
-		return fmt.Errorf("tecgonic: bundle extraction incomplete: only %d files extracted", len(entries))
+	if err := os.Remove(replaced); err != nil {
+		return fmt.Errorf("tecgonic: installing bundle: %w", err)
 	}
+	if err := os.Rename(destDir, replaced); err != nil {
+		return fmt.Errorf("tecgonic: installing bundle: %w", err)
+	}
+	if err := os.Rename(staging, destDir); err != nil {
+		_ = os.Rename(replaced, destDir)
+		return fmt.Errorf("tecgonic: installing bundle: %w", err)
+	}
+	_ = os.RemoveAll(replaced)
+	return nil
+}
 
+// copyBundleFile copies the bundle file src to path through tmp.
+func copyBundleFile(src, tmp, path string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("tecgonic: opening bundle: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	if err := writeFile(tmp, f); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("tecgonic: copying bundle: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("tecgonic: saving bundle: %w", err)
+	}
 	return nil
 }
 
+// extractEntry writes the bundle entry read from r to path, decompressing it
+// as it is read if it is gzipped, and returns the SHA-256 of the contents
+// written, in hex.
+func extractEntry(r io.Reader, path string) (string, error) {
+	br := bufio.NewReader(r)
+	var src io.Reader = br
+	if magic, _ := br.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return "", err
+		}
+		defer func() { _ = gr.Close() }()
+		src = gr
+	}
+	h := sha256.New()
+	if err := writeFile(path, io.TeeReader(src, h)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 func writeFile(path string, r io.Reader) error {
 	f, err := os.Create(path)
 	if err != nil {
@@ -0,0 +1,316 @@
+package tecgonic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// genFiles returns n generated bundle files, file000.sty and on.
+func genFiles(n int) map[string]string {
+	files := make(map[string]string, n)
+	for i := range n {
+		files[fmt.Sprintf("file%03d.sty", i)] = fmt.Sprintf("%% file %d\n", i)
+	}
+	return files
+}
+
+// withDigest adds a correct SHA256SUM to files and returns them.
+func withDigest(files map[string]string) map[string]string {
+	files["SHA256SUM"] = contentDigest(files) + "\n"
+	return files
+}
+
+// testBundle returns a generated itar bundle of n files with a SHA256SUM.
+func testBundle(t *testing.T, n int) []byte {
+	t.Helper()
+	return itarData(t, withDigest(genFiles(n)))
+}
+
+// bundleServer is a test server for a bundle file that supports range
+// requests and records their Range headers.
+type bundleServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	ranges []string
+}
+
+// serveBundle serves data. If cut is positive, the first response is cut off
+// after that many bytes.
+func serveBundle(t *testing.T, data []byte, cut int) *bundleServer {
+	t.Helper()
+	s := &bundleServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		first := len(s.ranges) == 0
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+		s.mu.Unlock()
+		if first && cut > 0 {
+			w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+			_, _ = w.Write(data[:cut])
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "bundle.tar", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Ranges returns the Range headers of the requests served so far.
+func (s *bundleServer) Ranges() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.ranges...)
+}
+
+func TestPrepareBundleInterrupted(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "bundle")
+
+	err := PrepareBundle(context.Background(), dest, serveBundle(t, testBundle(t, 120), 4096).URL, false, WithRetries(1, 0))
+	if err == nil {
+		t.Fatal("PrepareBundle succeeded on a truncated download")
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("bundle dir exists after an interrupted download: %v", err)
+	}
+	if matches, _ := filepath.Glob(dest + stagingSuffix + "*"); len(matches) != 0 {
+		t.Errorf("staging dirs left behind: %v", matches)
+	}
+	if info, err := os.Stat(dest + downloadSuffix); err != nil || info.Size() != 4096 {
+		t.Errorf("partial download not kept for resuming: %v", err)
+	}
+}
+
+func TestPrepareBundleRemovesStaleStaging(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "bundle")
+	stale := dest + stagingSuffix + "123"
+	if err := os.MkdirAll(stale, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := PrepareBundle(context.Background(), dest, serveBundle(t, testBundle(t, 120), 0).URL, false); err != nil {
+		t.Fatalf("PrepareBundle: %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale staging dir not removed: %v", err)
+	}
+	if !bundleComplete(dest) {
+		t.Error("bundle not marked complete")
+	}
+	if info, err := os.Stat(dest); err != nil || info.Mode().Perm() != 0o755 {
+		t.Errorf("bundle dir mode = %v, %v", info.Mode(), err)
+	}
+}
+
+func TestPrepareBundleReplacesIncomplete(t *testing.T) {
+	dest := t.TempDir()
+	// SHA256SUM alone, e.g. from an interrupted run of an older version,
+	// does not make a bundle complete.
+	if err := os.WriteFile(filepath.Join(dest, "SHA256SUM"), []byte("stale\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dest, "leftover.sty"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := PrepareBundle(context.Background(), dest, serveBundle(t, testBundle(t, 120), 0).URL, false); err != nil {
+		t.Fatalf("PrepareBundle: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "leftover.sty")); !os.IsNotExist(err) {
+		t.Error("files of the replaced bundle remain")
+	}
+	if _, err := VerifyBundle(dest); err != nil {
+		t.Errorf("VerifyBundle: %v", err)
+	}
+	if matches, _ := filepath.Glob(dest + replacedSuffix + "*"); len(matches) != 0 {
+		t.Errorf("replaced dirs left behind: %v", matches)
+	}
+
+	// A complete bundle is not downloaded again.
+	if err := PrepareBundle(context.Background(), dest, "http://invalid.invalid", false); err != nil {
+		t.Errorf("PrepareBundle over a complete bundle: %v", err)
+	}
+}
+
+func TestPrepareBundleKeepsOtherDirs(t *testing.T) {
+	dest := t.TempDir()
+	notes := filepath.Join(dest, "notes.txt")
+	if err := os.WriteFile(notes, []byte("keep"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := serveBundle(t, testBundle(t, 120), 0)
+	if err := PrepareBundle(context.Background(), dest, srv.URL, true); err == nil {
+		t.Error("PrepareBundle replaced a directory holding no bundle")
+	}
+	if err := InstallBundle(context.Background(), dest, bytes.NewReader(testBundle(t, 120))); err == nil {
+		t.Error("InstallBundle replaced a directory holding no bundle")
+	}
+	if data, err := os.ReadFile(notes); err != nil || string(data) != "keep" {
+		t.Errorf("notes.txt = %q, %v", data, err)
+	}
+}
+
+func TestRemoveStaleStagingLiteral(t *testing.T) {
+	parent := t.TempDir()
+	dest := filepath.Join(parent, "bun[dl]e")
+	stale := filepath.Join(parent, "bun[dl]e"+stagingSuffix+"1")
+	other := filepath.Join(parent, "bunle"+stagingSuffix+"1") // matched by dest as a glob
+	for _, dir := range []string{stale, other} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removeStaleStaging(dest)
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Error("stale staging dir not removed")
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("unrelated dir removed: %v", err)
+	}
+}
+
+func TestInstallBundle(t *testing.T) {
+	files := genFiles(120)
+	files["SVNREV"] = "1\n"
+
+	for _, tc := range []struct {
+		name string
+		path string
+	}{
+		{"itar", writeITar(t, files)},
+		{"ttb", writeTTB(t, files)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := os.Open(tc.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = f.Close() }()
+
+			dest := filepath.Join(t.TempDir(), "bundle")
+			if err := InstallBundle(context.Background(), dest, f, WithVerify("")); err != nil {
+				t.Fatalf("InstallBundle: %v", err)
+			}
+			got, err := os.ReadFile(filepath.Join(dest, "file007.sty"))
+			if err != nil || string(got) != files["file007.sty"] {
+				t.Errorf("file007.sty = %q, %v", got, err)
+			}
+			if matches, _ := filepath.Glob(dest + ".*"); len(matches) != 0 {
+				t.Errorf("temporary files left behind: %v", matches)
+			}
+		})
+	}
+}
+
+func TestInstallBundleCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	dest := filepath.Join(t.TempDir(), "bundle")
+	err := InstallBundle(ctx, dest, bytes.NewReader(testBundle(t, 120)))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("InstallBundle = %v, want context.Canceled", err)
+	}
+}
+
+func TestPrepareBundleFileURL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bundle.tar")
+	if err := os.WriteFile(path, testBundle(t, 120), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fileURL := (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+
+	dest := filepath.Join(t.TempDir(), "bundle")
+	if err := PrepareBundle(context.Background(), dest, fileURL, false); err != nil {
+		t.Fatalf("PrepareBundle: %v", err)
+	}
+	if !bundleComplete(dest) {
+		t.Error("bundle not marked complete")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("local bundle file removed: %v", err)
+	}
+
+	copied := filepath.Join(t.TempDir(), "copy.tar")
+	if err := DownloadBundle(context.Background(), copied, fileURL, false); err != nil {
+		t.Fatalf("DownloadBundle: %v", err)
+	}
+	b, err := OpenBundleArchive(copied)
+	if err != nil {
+		t.Fatalf("OpenBundleArchive: %v", err)
+	}
+	_ = b.Close()
+}
+
+func TestPrepareBundleRemovesBadDownload(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "bundle")
+	// Too few files to be a bundle
+	if err := PrepareBundle(context.Background(), dest, serveBundle(t, testBundle(t, 10), 0).URL, false); err == nil {
+		t.Fatal("PrepareBundle succeeded with an incomplete bundle")
+	}
+	if _, err := os.Stat(dest + downloadSuffix); !os.IsNotExist(err) {
+		t.Errorf("download of a bad bundle kept: %v", err)
+	}
+}
+
+func TestExtractBundleFileCorruptEntry(t *testing.T) {
+	path := writeITar(t, genFiles(200))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Corrupt the gzip header of the first entry's contents, after its tar
+	// header.
+	data[512+3] = 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = extractBundleFile(path, t.TempDir(), prepareBundleConfig{workers: 4})
+	if err == nil {
+		t.Error("extractBundleFile succeeded on a corrupt entry")
+	}
+}
+
+// BenchmarkExtractBundle compares extracting a bundle file with one worker
+// and with the default pool of GOMAXPROCS workers; run it with -cpu to vary
+// the pool size.
+func BenchmarkExtractBundle(b *testing.B) {
+	files := genFiles(2000)
+	for name, data := range files {
+		files[name] = strings.Repeat(data, 2000)
+	}
+	path := writeITar(b, files)
+
+	pools := []int{1}
+	if n := runtime.GOMAXPROCS(0); n > 1 {
+		pools = append(pools, n)
+	}
+	for _, workers := range pools {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for range b.N {
+				dest, err := os.MkdirTemp(b.TempDir(), "bundle")
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, _, err := extractBundleFile(path, dest, prepareBundleConfig{workers: workers}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
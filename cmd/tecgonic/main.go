@@ -0,0 +1,118 @@
+// Command tecgonic inspects Tectonic bundles.
+//
+// Usage:
+//
+//	tecgonic [-bundle dir-or-file] <command> [arguments]
+//
+// The commands are:
+//
+//	info              describe the bundle
+//	which NAME...     print the bundle file each NAME resolves to, like kpsewhich
+//	search PATTERN    list the bundle files matching a glob pattern, e.g. "*.cls"
+//	package NAME      list the files of a LaTeX package or class
+//
+// The bundle is an extracted bundle directory, as prepared by
+// tecgonic.PrepareBundle, or an itar or TTB bundle file.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mgilbir/tecgonic"
+)
+
+// errNotFound fails a lookup that found nothing, without a message.
+var errNotFound = errors.New("not found")
+
+func main() {
+	defaultBundle := ""
+	if userCacheDir, err := os.UserCacheDir(); err == nil {
+		defaultBundle = filepath.Join(userCacheDir, "tecgonic", "bundle")
+	}
+
+	bundlePath := flag.String("bundle", defaultBundle, "path to the bundle directory or file")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: tecgonic [-bundle dir-or-file] info | which NAME... | search PATTERN | package NAME")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	err := run(*bundlePath, flag.Arg(0), flag.Args()[1:])
+	switch {
+	case errors.Is(err, errNotFound):
+		os.Exit(1)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(bundlePath, cmd string, args []string) error {
+	b, err := tecgonic.OpenBundle(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = b.Close() }()
+
+	switch cmd {
+	case "info":
+		fmt.Println(b)
+		return nil
+
+	case "which":
+		if len(args) == 0 {
+			return errors.New("which: no file names given")
+		}
+		var err error
+		for _, name := range args {
+			found, ok := b.Lookup(name)
+			if !ok {
+				err = errNotFound
+				continue
+			}
+			if b.IsDir() {
+				found = filepath.Join(b.Path(), found)
+			}
+			fmt.Println(found)
+		}
+		return err
+
+	case "search":
+		if len(args) != 1 {
+			return errors.New("search: want one pattern")
+		}
+		matches, err := b.Search(args[0])
+		if err != nil {
+			return err
+		}
+		for _, name := range matches {
+			fmt.Println(name)
+		}
+		if len(matches) == 0 {
+			return errNotFound
+		}
+		return nil
+
+	case "package":
+		if len(args) != 1 {
+			return errors.New("package: want one package name")
+		}
+		files, err := b.PackageFiles(args[0])
+		if err != nil {
+			return err
+		}
+		for _, name := range files {
+			fmt.Println(name)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown command %q", cmd)
+}
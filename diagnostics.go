@@ -0,0 +1,337 @@
+package tecgonic
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Severity classifies how serious a Diagnostic is.
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// Category identifies the kind of problem a Diagnostic reports.
+type Category int
+
+const (
+	CategoryOther              Category = iota // anything not covered below
+	CategoryOverfullBox                        // Overfull \hbox or \vbox
+	CategoryUnderfullBox                       // Underfull \hbox or \vbox
+	CategoryUndefinedReference                 // \ref or \pageref to an unknown label
+	CategoryUndefinedCitation                  // \cite of an unknown key
+	CategoryMissingCharacter                   // glyph not present in the selected font
+	CategoryMissingFile                        // \input, \usepackage, etc. of a file that does not exist
+	CategoryFont                               // font shape substitutions and other font warnings
+)
+
+func (c Category) String() string {
+	switch c {
+	case CategoryOther:
+		return "other"
+	case CategoryOverfullBox:
+		return "overfull-box"
+	case CategoryUnderfullBox:
+		return "underfull-box"
+	case CategoryUndefinedReference:
+		return "undefined-reference"
+	case CategoryUndefinedCitation:
+		return "undefined-citation"
+	case CategoryMissingCharacter:
+		return "missing-character"
+	case CategoryMissingFile:
+		return "missing-file"
+	case CategoryFont:
+		return "font"
+	default:
+		return fmt.Sprintf("Category(%d)", int(c))
+	}
+}
+
+// Diagnostic is a single error or warning reported by the engine.
+type Diagnostic struct {
+	Severity Severity
+	Category Category
+	File     string // source file the message refers to, empty if unknown
+	Line     int    // 1-based line in File, 0 if unknown
+	Message  string // the message itself, without severity prefix or location
+	Context  string // following log lines that show where the problem occurred
+}
+
+func (d Diagnostic) String() string {
+	var b strings.Builder
+	b.WriteString(d.Severity.String())
+	b.WriteString(": ")
+	if d.File != "" {
+		b.WriteString(d.File)
+		b.WriteString(":")
+		if d.Line > 0 {
+			b.WriteString(strconv.Itoa(d.Line))
+			b.WriteString(":")
+		}
+		b.WriteString(" ")
+	}
+	b.WriteString(d.Message)
+	return b.String()
+}
+
+var (
+	// Tectonic's own console format, e.g. "error: input.tex:3: Undefined control sequence".
+	tectonicMsgRe = regexp.MustCompile(`^(error|warning): (?:([^\s:]+\.\w+):(\d+): )?(.*)$`)
+
+	// "l.3 \foo" context lines that follow a TeX error.
+	errLineRe = regexp.MustCompile(`^l\.(\d+)`)
+
+	// "... on input line 12." suffix of LaTeX warnings.
+	inputLineRe = regexp.MustCompile(`on input line (\d+)`)
+
+	// "in paragraph at lines 10--12" / "detected at line 40" in box warnings.
+	boxLineRe = regexp.MustCompile(`(?:at lines? )(\d+)`)
+
+	// "LaTeX Warning: ...", "Package hyperref Warning: ...", "Class foo Warning: ...".
+	latexWarningRe = regexp.MustCompile(`^(?:LaTeX|Package \S+|Class \S+|LaTeX Font) Warning: (.*)$`)
+
+	// LaTeX error message for missing files.
+	missingFileRe = regexp.MustCompile("File `[^']+' not found|I can't find file|could not open file|not found in bundle")
+
+	// Start of a file in the log: "(./chapter.tex" or "(/bundle/article.cls".
+	logFileRe = regexp.MustCompile(`^\(([^\s()]+\.[A-Za-z0-9]+)`)
+)
+
+// ParseLog extracts structured diagnostics from tectonic's diagnostic output
+// or from a XeTeX .log file. It recognises TeX errors, LaTeX and package
+// warnings, overfull and underfull boxes, undefined references and citations,
+// missing files and missing characters. Lines it does not understand are
+// ignored.
+func ParseLog(log string) []Diagnostic {
+	lines := strings.Split(strings.ReplaceAll(log, "\r\n", "\n"), "\n")
+
+	var diags []Diagnostic
+	var files fileStack
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		if m := tectonicMsgRe.FindStringSubmatch(line); m != nil {
+			d := Diagnostic{Severity: SeverityWarning, File: m[2], Message: m[4]}
+			if m[1] == "error" {
+				d.Severity = SeverityError
+			}
+			d.Line, _ = strconv.Atoi(m[3])
+			d.Category = categorize(d.Message)
+			if d.Line == 0 {
+				d.Line = lineFromMessage(d.Message)
+			}
+			diags = append(diags, d)
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "! "):
+			d := Diagnostic{
+				Severity: SeverityError,
+				File:     files.current(),
+				Message:  strings.TrimPrefix(line, "! "),
+			}
+			d.Category = categorize(d.Message)
+			var ctx []string
+			for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+				i++
+				ctx = append(ctx, lines[i])
+				if m := errLineRe.FindStringSubmatch(lines[i]); m != nil && d.Line == 0 {
+					d.Line, _ = strconv.Atoi(m[1])
+				}
+			}
+			d.Context = strings.Join(ctx, "\n")
+			diags = append(diags, d)
+
+		case strings.HasPrefix(line, "Overfull \\") || strings.HasPrefix(line, "Underfull \\"):
+			d := Diagnostic{
+				Severity: SeverityWarning,
+				File:     files.current(),
+				Message:  line,
+				Category: CategoryUnderfullBox,
+			}
+			if strings.HasPrefix(line, "Overfull") {
+				d.Category = CategoryOverfullBox
+			}
+			d.Line = lineFromMessage(line)
+			var ctx []string
+			for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" && !isLogMessage(lines[i+1]) {
+				i++
+				ctx = append(ctx, lines[i])
+			}
+			d.Context = strings.Join(ctx, "\n")
+			diags = append(diags, d)
+
+		case strings.HasPrefix(line, "Missing character: "):
+			diags = append(diags, Diagnostic{
+				Severity: SeverityWarning,
+				Category: CategoryMissingCharacter,
+				File:     files.current(),
+				Message:  strings.TrimPrefix(line, "Missing character: "),
+			})
+
+		case latexWarningRe.MatchString(line):
+			msg := latexWarningRe.FindStringSubmatch(line)[1]
+			prefix := line[:len(line)-len(msg)]
+			// Warnings continue on following lines, indented or prefixed with "(pkg)".
+			for i+1 < len(lines) && isContinuation(lines[i+1]) {
+				i++
+				msg += " " + trimContinuation(lines[i])
+			}
+			msg = strings.Join(strings.Fields(msg), " ")
+			d := Diagnostic{
+				Severity: SeverityWarning,
+				File:     files.current(),
+				Message:  msg,
+				Category: categorize(prefix + msg),
+			}
+			d.Line = lineFromMessage(msg)
+			diags = append(diags, d)
+
+		default:
+			files.scan(line)
+		}
+	}
+	return diags
+}
+
+// filterWarnings returns the warnings in diags that belong to one of
+// categories, or all warnings if categories is empty.
+func filterWarnings(diags []Diagnostic, categories []Category) []Diagnostic {
+	var out []Diagnostic
+	for _, d := range diags {
+		if d.Severity != SeverityWarning {
+			continue
+		}
+		if len(categories) > 0 && !slices.Contains(categories, d.Category) {
+			continue
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+// categorize classifies a diagnostic from its message text.
+func categorize(msg string) Category {
+	if m := latexWarningRe.FindStringSubmatch(msg); m != nil {
+		if strings.HasPrefix(msg, "LaTeX Font Warning") {
+			return CategoryFont
+		}
+		msg = m[1]
+	}
+	switch {
+	case strings.HasPrefix(msg, "Overfull \\"):
+		return CategoryOverfullBox
+	case strings.HasPrefix(msg, "Underfull \\"):
+		return CategoryUnderfullBox
+	case strings.HasPrefix(msg, "Reference ") && strings.Contains(msg, "undefined"),
+		strings.Contains(msg, "There were undefined references"):
+		return CategoryUndefinedReference
+	case strings.HasPrefix(msg, "Citation ") && strings.Contains(msg, "undefined"),
+		strings.Contains(msg, "There were undefined citations"):
+		return CategoryUndefinedCitation
+	case strings.HasPrefix(msg, "Missing character"):
+		return CategoryMissingCharacter
+	case missingFileRe.MatchString(msg):
+		return CategoryMissingFile
+	case strings.HasPrefix(msg, "Font shape "):
+		return CategoryFont
+	default:
+		return CategoryOther
+	}
+}
+
+// lineFromMessage extracts a line number embedded in a message, such as
+// "on input line 12" or "in paragraph at lines 10--12".
+func lineFromMessage(msg string) int {
+	if m := inputLineRe.FindStringSubmatch(msg); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return n
+	}
+	if m := boxLineRe.FindStringSubmatch(msg); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return n
+	}
+	return 0
+}
+
+// isContinuation reports whether line continues a multi-line LaTeX warning.
+func isContinuation(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" {
+		return false
+	}
+	return strings.HasPrefix(line, " ") || strings.HasPrefix(trimmed, "(") && !logFileRe.MatchString(trimmed)
+}
+
+// trimContinuation strips the indentation and "(package)" prefix from a
+// warning continuation line.
+func trimContinuation(line string) string {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "(") {
+		if end := strings.IndexByte(line, ')'); end > 0 {
+			line = strings.TrimSpace(line[end+1:])
+		}
+	}
+	return line
+}
+
+// isLogMessage reports whether line starts a new log message.
+func isLogMessage(line string) bool {
+	return strings.HasPrefix(line, "! ") ||
+		strings.HasPrefix(line, "Overfull \\") ||
+		strings.HasPrefix(line, "Underfull \\") ||
+		latexWarningRe.MatchString(line) ||
+		tectonicMsgRe.MatchString(line)
+}
+
+// fileStack tracks which source file the log is currently in, following the
+// "(file" ... ")" nesting TeX writes as it opens and closes files.
+type fileStack []string
+
+func (s *fileStack) current() string {
+	for i := len(*s) - 1; i >= 0; i-- {
+		if (*s)[i] != "" {
+			return (*s)[i]
+		}
+	}
+	return ""
+}
+
+func (s *fileStack) scan(line string) {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '(':
+			name := ""
+			if m := logFileRe.FindStringSubmatch(line[i:]); m != nil {
+				name = strings.TrimPrefix(m[1], "./")
+				i += len(m[0]) - 1
+			}
+			*s = append(*s, name)
+		case ')':
+			if len(*s) > 0 {
+				*s = (*s)[:len(*s)-1]
+			}
+		}
+	}
+}
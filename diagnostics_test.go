@@ -0,0 +1,124 @@
+package tecgonic
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLogTectonicFormat(t *testing.T) {
+	log := `note: Running TeX ...
+error: input.tex:3: Undefined control sequence
+warning: input.tex:12: Overfull \hbox (3.2pt too wide) in paragraph at lines 12--13
+warning: chapters/one.tex:4: LaTeX Warning: Reference ` + "`sec:intro'" + ` on page 1 undefined on input line 4.
+error: halted on potentially-recoverable error as specified
+`
+	got := ParseLog(log)
+	want := []Diagnostic{
+		{Severity: SeverityError, Category: CategoryOther, File: "input.tex", Line: 3, Message: "Undefined control sequence"},
+		{Severity: SeverityWarning, Category: CategoryOverfullBox, File: "input.tex", Line: 12, Message: `Overfull \hbox (3.2pt too wide) in paragraph at lines 12--13`},
+		{Severity: SeverityWarning, Category: CategoryUndefinedReference, File: "chapters/one.tex", Line: 4, Message: "LaTeX Warning: Reference `sec:intro' on page 1 undefined on input line 4."},
+		{Severity: SeverityError, Category: CategoryOther, Message: "halted on potentially-recoverable error as specified"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseLog mismatch\ngot:  %#v\nwant: %#v", got, want)
+	}
+}
+
+func TestParseLogTeXFormat(t *testing.T) {
+	log := `This is XeTeX, Version 3.141592653-2.6-0.999995 (TeX Live 2023/Tectonic)
+(./input.tex
+LaTeX2e <2023-06-01> patch level 1
+(/bundle/article.cls
+Document Class: article 2023/05/17 v1.4n Standard LaTeX document class
+(/bundle/size10.clo))
+(./chapters/one.tex
+! Undefined control sequence.
+l.7 \foo
+        bar
+
+Overfull \hbox (15.0pt too wide) in paragraph at lines 9--10
+[]\TU/lmr/m/n/10 averyveryverylongword|
+
+Missing character: There is no ☃ in font lmroman10-regular!
+)
+LaTeX Warning: Citation ` + "`knuth84'" + ` on page 1 undefined on input line 20.
+
+Package hyperref Warning: Token not allowed in a PDF string (Unicode):
+(hyperref)                removing ` + "`math shift'" + ` on input line 22.
+
+LaTeX Font Warning: Font shape ` + "`TU/lmr/bx/sc'" + ` undefined
+(Font)              using ` + "`TU/lmr/bx/n'" + ` instead on input line 30.
+
+! LaTeX Error: File ` + "`missing.sty'" + ` not found.
+
+Type X to quit or <RETURN> to proceed,
+LaTeX Warning: There were undefined references.
+)
+`
+	got := ParseLog(log)
+	want := []Diagnostic{
+		{Severity: SeverityError, Category: CategoryOther, File: "chapters/one.tex", Line: 7, Message: "Undefined control sequence.", Context: "l.7 \\foo\n        bar"},
+		{Severity: SeverityWarning, Category: CategoryOverfullBox, File: "chapters/one.tex", Line: 9, Message: `Overfull \hbox (15.0pt too wide) in paragraph at lines 9--10`, Context: `[]\TU/lmr/m/n/10 averyveryverylongword|`},
+		{Severity: SeverityWarning, Category: CategoryMissingCharacter, File: "chapters/one.tex", Message: "There is no ☃ in font lmroman10-regular!"},
+		{Severity: SeverityWarning, Category: CategoryUndefinedCitation, File: "input.tex", Line: 20, Message: "Citation `knuth84' on page 1 undefined on input line 20."},
+		{Severity: SeverityWarning, Category: CategoryOther, File: "input.tex", Line: 22, Message: "Token not allowed in a PDF string (Unicode): removing `math shift' on input line 22."},
+		{Severity: SeverityWarning, Category: CategoryFont, File: "input.tex", Line: 30, Message: "Font shape `TU/lmr/bx/sc' undefined using `TU/lmr/bx/n' instead on input line 30."},
+		{Severity: SeverityError, Category: CategoryMissingFile, File: "input.tex", Message: "LaTeX Error: File `missing.sty' not found."},
+		{Severity: SeverityWarning, Category: CategoryUndefinedReference, File: "input.tex", Message: "There were undefined references."},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ParseLog returned %d diagnostics, want %d:\n%v", len(got), len(want), got)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("diagnostic %d mismatch\ngot:  %#v\nwant: %#v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestDiagnosticString(t *testing.T) {
+	d := Diagnostic{Severity: SeverityError, File: "input.tex", Line: 3, Message: "Undefined control sequence."}
+	if got, want := d.String(), "error: input.tex:3: Undefined control sequence."; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestFilterWarnings(t *testing.T) {
+	diags := []Diagnostic{
+		{Severity: SeverityError, Category: CategoryOther, Message: "Undefined control sequence."},
+		{Severity: SeverityWarning, Category: CategoryUndefinedReference, Message: "Reference `a' on page 1 undefined"},
+		{Severity: SeverityWarning, Category: CategoryOverfullBox, Message: `Overfull \hbox`},
+		{Severity: SeverityWarning, Category: CategoryUndefinedCitation, Message: "Citation `b' on page 1 undefined"},
+	}
+
+	if got := filterWarnings(diags, nil); len(got) != 3 {
+		t.Errorf("filterWarnings(nil) returned %d diagnostics, want 3", len(got))
+	}
+	got := filterWarnings(diags, []Category{CategoryUndefinedReference, CategoryUndefinedCitation})
+	if len(got) != 2 || got[0].Category != CategoryUndefinedReference || got[1].Category != CategoryUndefinedCitation {
+		t.Errorf("filterWarnings(refs, cites) = %v", got)
+	}
+	if got := filterWarnings(diags, []Category{CategoryMissingCharacter}); got != nil {
+		t.Errorf("filterWarnings(missing-character) = %v, want nil", got)
+	}
+}
+
+func TestWarningsError(t *testing.T) {
+	var warnings []Diagnostic
+	for i := 0; i < 7; i++ {
+		warnings = append(warnings, Diagnostic{Severity: SeverityWarning, Message: "Reference undefined"})
+	}
+	wasmErr := errors.New("wasm")
+	err := &CompileError{Cause: warningsError(warnings), WasmErr: wasmErr}
+	if !errors.Is(err, ErrWarnings) {
+		t.Fatalf("errors.Is(%v, ErrWarnings) = false", err)
+	}
+	if got := errors.Unwrap(err); got != wasmErr {
+		t.Errorf("errors.Unwrap = %v, want WasmErr", got)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "and 2 more") {
+		t.Errorf("Error() = %q, want it to mention the 2 unlisted warnings", msg)
+	}
+}
@@ -0,0 +1,218 @@
+package tecgonic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultDownloadAttempts = 5
+	defaultDownloadBackoff  = time.Second
+	maxDownloadBackoff      = 30 * time.Second
+)
+
+// WithHTTPClient sets the HTTP client of the default HTTPFetcher, e.g. to go
+// through a proxy or a custom transport. The default is http.DefaultClient.
+func WithHTTPClient(client *http.Client) PrepareBundleOption {
+	return func(c *prepareBundleConfig) {
+		c.client = client
+	}
+}
+
+// WithMirrors adds mirror URLs serving the same bundle file. Failed download
+// attempts move on to the next URL in turn, starting with the bundle URL.
+func WithMirrors(urls ...string) PrepareBundleOption {
+	return func(c *prepareBundleConfig) {
+		c.mirrors = append(c.mirrors, urls...)
+	}
+}
+
+// WithRetries sets how many times a download is attempted in total, across
+// all URLs, and the delay before the first retry, which doubles after each
+// further failure up to 30 s. The default is 5 attempts starting at 1 s.
+func WithRetries(attempts int, backoff time.Duration) PrepareBundleOption {
+	return func(c *prepareBundleConfig) {
+		c.attempts = attempts
+		c.backoff = backoff
+	}
+}
+
+// downloadResumable downloads the file at the first of urls that works to
+// path. The file is kept if the download fails, and later attempts, in this
+// call or the next, continue it if the fetcher is a RangeFetcher. The bundle
+// URL, size and version are kept in path + ".partial" so a download of a
+// different or changed bundle starts over.
+func downloadResumable(ctx context.Context, urls []string, path string, cfg prepareBundleConfig) error {
+	attempts := cfg.attempts
+	if attempts <= 0 {
+		attempts = defaultDownloadAttempts
+	}
+	backoff := cfg.backoff
+	if backoff <= 0 {
+		backoff = defaultDownloadBackoff
+	}
+	fetcher := cfg.bundleFetcher()
+
+	dead := make([]bool, len(urls))
+	var lastErr error
+	for attempt, i := 0, 0; attempt < attempts; attempt, i = attempt+1, i+1 {
+		// Pick the next URL that has not failed permanently
+		for n := 0; n < len(urls) && dead[i%len(urls)]; n++ {
+			i++
+		}
+		if dead[i%len(urls)] {
+			break
+		}
+		url := urls[i%len(urls)]
+
+		if attempt > 0 {
+			cfg.progress.report(ProgressEvent{Phase: PhaseDownload, TotalBytes: -1, Err: lastErr, RetryIn: backoff})
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("tecgonic: downloading bundle: %w", ctx.Err())
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, maxDownloadBackoff)
+		}
+
+		err := downloadAttempt(ctx, fetcher, urls[0], url, path, cfg.progress)
+		if err == nil {
+			_ = os.Remove(path + ".partial")
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("tecgonic: downloading bundle: %w", ctx.Err())
+		}
+		if permanentFetchError(err) {
+			dead[i%len(urls)] = true
+		}
+		lastErr = err
+	}
+	return fmt.Errorf("tecgonic: downloading bundle: %w", lastErr)
+}
+
+// permanentFetchError reports whether retrying a fetch that failed with err
+// is pointless.
+func permanentFetchError(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) || errors.Is(err, fs.ErrInvalid)
+}
+
+// partialInfo identifies the bundle a partial download belongs to.
+type partialInfo struct {
+	url     string // bundle URL; mirrors serve the same file
+	size    int64  // -1 if unknown
+	version string // empty if unknown
+}
+
+// readPartialInfo reads the partialInfo kept for the partial download at
+// path. It reports false if there is none.
+func readPartialInfo(path string) (partialInfo, bool) {
+	data, err := os.ReadFile(path + ".partial")
+	if err != nil {
+		return partialInfo{}, false
+	}
+	lines := strings.SplitN(string(data), "\n", 3)
+	if len(lines) != 3 {
+		return partialInfo{}, false
+	}
+	size, err := strconv.ParseInt(lines[1], 10, 64)
+	if err != nil {
+		return partialInfo{}, false
+	}
+	return partialInfo{url: lines[0], size: size, version: lines[2]}, true
+}
+
+func writePartialInfo(path string, info partialInfo) error {
+	data := fmt.Sprintf("%s\n%d\n%s", info.url, info.size, info.version)
+	return os.WriteFile(path+".partial", []byte(data), 0o644)
+}
+
+// changed reports whether a stream of size and version serves a different
+// bundle than the one info was recorded for.
+func (info partialInfo) changed(size int64, version string) bool {
+	return info.size >= 0 && size >= 0 && size != info.size ||
+		info.version != "" && version != "" && version != info.version
+}
+
+// downloadAttempt fetches url, a URL of bundleURL, once, appending to the
+// partial file at path if the fetcher can resume it.
+func downloadAttempt(ctx context.Context, fetcher BundleFetcher, bundleURL, url, path string, progress ProgressFunc) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	info, ok := readPartialInfo(path)
+	if offset > 0 && (!ok || info.url != bundleURL) {
+		// The partial download is of another bundle: start over
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		offset = 0
+	}
+
+	var s *BundleStream
+	if rf, ok := fetcher.(RangeFetcher); ok && offset > 0 {
+		s, err = rf.OpenRange(ctx, url, offset, -1)
+	} else {
+		s, err = fetcher.Open(ctx, url)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = s.Close() }()
+
+	if s.Offset == offset && offset > 0 && info.changed(s.Size, s.Version) {
+		// The bundle changed since the partial download: start over
+		_ = s.Close()
+		if s, err = fetcher.Open(ctx, url); err != nil {
+			return err
+		}
+	}
+	switch s.Offset {
+	case offset:
+	case 0:
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		offset = 0
+	default:
+		return fmt.Errorf("%s: fetcher resumed at %d, want %d", url, s.Offset, offset)
+	}
+	if err := writePartialInfo(path, partialInfo{url: bundleURL, size: s.Size, version: s.Version}); err != nil {
+		return err
+	}
+
+	pr := newProgressReader(s, PhaseDownload, offset, s.Size, progress)
+	n, err := io.Copy(f, pr)
+	if err != nil {
+		return err
+	}
+	if s.Size >= 0 && offset+n != s.Size {
+		return fmt.Errorf("%s: download ended at %d of %d bytes", url, offset+n, s.Size)
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	progress.report(pr.event(true))
+	return nil
+}
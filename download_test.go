@@ -0,0 +1,150 @@
+package tecgonic
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPrepareBundleResumes(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "bundle")
+	srv := serveBundle(t, testBundle(t, 120), 4096)
+
+	// The first call is interrupted and keeps what it downloaded.
+	if err := PrepareBundle(context.Background(), dest, srv.URL, false, WithRetries(1, 0)); err == nil {
+		t.Fatal("PrepareBundle succeeded on a truncated download")
+	}
+	// The next call resumes it.
+	if err := PrepareBundle(context.Background(), dest, srv.URL, false); err != nil {
+		t.Fatalf("PrepareBundle: %v", err)
+	}
+	if got := srv.Ranges(); len(got) != 2 || got[1] != "bytes=4096-" {
+		t.Errorf("Range headers = %q, want a resume from byte 4096", got)
+	}
+	if _, err := VerifyBundle(dest); err != nil {
+		t.Errorf("VerifyBundle: %v", err)
+	}
+	if _, err := os.Stat(dest + downloadSuffix); !os.IsNotExist(err) {
+		t.Errorf("download file not removed: %v", err)
+	}
+}
+
+func TestPrepareBundleRestartsChangedDownload(t *testing.T) {
+	data := testBundle(t, 120)
+	srv := serveBundle(t, data, 0)
+
+	for _, tc := range []struct {
+		name string
+		info partialInfo
+	}{
+		{"url", partialInfo{url: "https://example.org/other.tar", size: int64(len(data))}},
+		{"size", partialInfo{url: srv.URL, size: int64(len(data)) + 1}},
+	} {
+		dest := filepath.Join(t.TempDir(), "bundle")
+		if err := os.WriteFile(dest+downloadSuffix, []byte("stale"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := writePartialInfo(dest+downloadSuffix, tc.info); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := PrepareBundle(context.Background(), dest, srv.URL, false); err != nil {
+			t.Fatalf("%s: PrepareBundle: %v", tc.name, err)
+		}
+		if got := srv.Ranges(); got[len(got)-1] != "" {
+			t.Errorf("%s: Range headers = %q, want a download from the start", tc.name, got)
+		}
+		if _, err := VerifyBundle(dest); err != nil {
+			t.Errorf("%s: VerifyBundle: %v", tc.name, err)
+		}
+	}
+}
+
+func TestPrepareBundleRetries(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "bundle")
+	srv := serveBundle(t, testBundle(t, 120), 4096)
+
+	if err := PrepareBundle(context.Background(), dest, srv.URL, false, WithRetries(2, time.Millisecond)); err != nil {
+		t.Fatalf("PrepareBundle: %v", err)
+	}
+	if got := srv.Ranges(); len(got) != 2 || got[1] != "bytes=4096-" {
+		t.Errorf("Range headers = %q, want a resume from byte 4096", got)
+	}
+}
+
+func TestPrepareBundleMirrors(t *testing.T) {
+	var primaryHits atomic.Int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryHits.Add(1)
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(primary.Close)
+	var flakyHits atomic.Int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flakyHits.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(flaky.Close)
+	mirror := serveBundle(t, testBundle(t, 120), 0)
+
+	dest := filepath.Join(t.TempDir(), "bundle")
+	err := PrepareBundle(context.Background(), dest, primary.URL, false,
+		WithMirrors(flaky.URL, mirror.URL), WithRetries(5, time.Millisecond))
+	if err != nil {
+		t.Fatalf("PrepareBundle: %v", err)
+	}
+	if !bundleComplete(dest) {
+		t.Error("bundle not marked complete")
+	}
+	if primaryHits.Load() != 1 || flakyHits.Load() != 1 {
+		t.Errorf("hits = %d primary, %d flaky; want 1 each", primaryHits.Load(), flakyHits.Load())
+	}
+}
+
+func TestPrepareBundleNotFound(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	dest := filepath.Join(t.TempDir(), "bundle")
+	if err := PrepareBundle(context.Background(), dest, srv.URL, false, WithRetries(5, time.Millisecond)); err == nil {
+		t.Fatal("PrepareBundle succeeded against a 404")
+	}
+	if hits.Load() != 1 {
+		t.Errorf("a 404 was retried: %d requests", hits.Load())
+	}
+}
+
+// countingTransport counts the requests it forwards.
+type countingTransport struct {
+	n atomic.Int32
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.n.Add(1)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestDownloadBundleHTTPClient(t *testing.T) {
+	transport := &countingTransport{}
+	path := filepath.Join(t.TempDir(), "bundle.tar")
+	srv := serveBundle(t, testBundle(t, 10), 0)
+
+	if err := DownloadBundle(context.Background(), path, srv.URL, false, WithHTTPClient(&http.Client{Transport: transport})); err != nil {
+		t.Fatalf("DownloadBundle: %v", err)
+	}
+	if transport.n.Load() != 1 {
+		t.Errorf("custom client made %d requests, want 1", transport.n.Load())
+	}
+	if _, err := os.Stat(path + ".partial"); !os.IsNotExist(err) {
+		t.Errorf("partial file left behind: %v", err)
+	}
+}
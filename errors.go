@@ -1,24 +1,65 @@
 package tecgonic
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// Sentinel causes of compilation failures. Test for them with errors.Is;
+// a *CompileError matches the sentinel in its Cause field.
+var (
+	// ErrCanceled means the context passed to Compile was canceled.
+	ErrCanceled = errors.New("compilation canceled")
+	// ErrTimeout means the deadline of the context passed to Compile expired.
+	ErrTimeout = errors.New("compilation timed out")
+	// ErrMissingFile means the document referenced a file that exists neither
+	// in the project nor in the bundle.
+	ErrMissingFile = errors.New("missing input file")
+	// ErrMissingFont means a font the document selected could not be found.
+	ErrMissingFont = errors.New("missing font")
+	// ErrOutOfMemory means the engine ran out of memory or exceeded one of
+	// TeX's internal capacities.
+	ErrOutOfMemory = errors.New("out of memory")
+	// ErrNoFormat means the bundle has no latex.fmt; run GenerateFormat first.
+	ErrNoFormat = errors.New("format file not found")
+	// ErrBundleMissing means no bundle was configured or it does not exist.
+	ErrBundleMissing = errors.New("bundle not found")
+	// ErrWarnings means the engine succeeded but reported warnings selected
+	// with WithFailOnWarnings.
+	ErrWarnings = errors.New("warnings treated as errors")
+)
 
 // CompileError represents a failure during LaTeX compilation.
 type CompileError struct {
-	ExitCode int32  // 1=TeX error, 2=panic/trap
-	Logs     string // stderr output captured from tectonic
-	WasmErr  error  // underlying wazero error (for traps), nil for normal TeX errors
+	ExitCode    int32        // 1=TeX error, 2=panic/trap or interrupted run, 0=failed after the engine succeeded (see Cause)
+	Logs        string       // stderr output captured from tectonic
+	WasmErr     error        // underlying wazero error (for traps), nil for normal TeX errors
+	Cause       error        // classified cause, matching one of the Err* sentinels; nil if unknown
+	Diagnostics []Diagnostic // errors and warnings parsed from the TeX log or Logs
 }
 
 func (e *CompileError) Error() string {
 	var kind string
-	switch e.ExitCode {
-	case 1:
+	switch {
+	case e.ExitCode == 0 && e.Cause == nil:
+		kind = "compilation failed"
+	case e.ExitCode == 0 || e.interrupted():
+		kind = e.Cause.Error()
+	case e.ExitCode == 1:
 		kind = "TeX compilation error"
-	case 2:
+	case e.ExitCode == 2:
 		kind = "WASM engine panic/trap"
 	default:
 		kind = fmt.Sprintf("exit code %d", e.ExitCode)
 	}
+	if e.Cause != nil && e.ExitCode != 0 && !e.interrupted() {
+		kind += " (" + e.Cause.Error() + ")"
+	}
 
 	msg := kind
 	if e.WasmErr != nil {
@@ -35,12 +76,150 @@ func (e *CompileError) IsTexError() bool {
 	return e.ExitCode == 1
 }
 
-// IsPanic returns true if the error is a WASM engine trap (exit code 2).
+// IsPanic returns true if the error is a WASM engine trap (exit code 2) that
+// did not come from cancellation, a deadline or a resource limit.
 func (e *CompileError) IsPanic() bool {
-	return e.ExitCode == 2
+	return e.ExitCode == 2 && !e.interrupted()
+}
+
+// interrupted reports whether the run was stopped from the host.
+func (e *CompileError) interrupted() bool {
+	return errors.Is(e.Cause, ErrCanceled) || errors.Is(e.Cause, ErrTimeout) || errors.Is(e.Cause, ErrLimitExceeded)
+}
+
+// IsCanceled returns true if the compilation was stopped by context cancellation.
+func (e *CompileError) IsCanceled() bool {
+	return errors.Is(e.Cause, ErrCanceled)
+}
+
+// IsTimeout returns true if the compilation was stopped by a context deadline.
+func (e *CompileError) IsTimeout() bool {
+	return errors.Is(e.Cause, ErrTimeout)
+}
+
+// IsMissingFile returns true if the document referenced a file that could not be found.
+func (e *CompileError) IsMissingFile() bool {
+	return errors.Is(e.Cause, ErrMissingFile)
+}
+
+// IsMissingFont returns true if a font the document selected could not be found.
+func (e *CompileError) IsMissingFont() bool {
+	return errors.Is(e.Cause, ErrMissingFont)
+}
+
+// IsOutOfMemory returns true if the engine ran out of memory or TeX capacity.
+func (e *CompileError) IsOutOfMemory() bool {
+	return errors.Is(e.Cause, ErrOutOfMemory)
+}
+
+// IsNoFormat returns true if the engine could not load the format file.
+func (e *CompileError) IsNoFormat() bool {
+	return errors.Is(e.Cause, ErrNoFormat)
+}
+
+// IsLimitExceeded returns true if the compilation exceeded one of its resource
+// limits; errors.Is with ErrMemoryLimit etc. tells which one.
+func (e *CompileError) IsLimitExceeded() bool {
+	return errors.Is(e.Cause, ErrLimitExceeded)
 }
 
 // Unwrap returns the underlying wazero error for errors.Is/errors.As chaining.
 func (e *CompileError) Unwrap() error {
 	return e.WasmErr
 }
+
+// Is reports whether the classified cause matches target, so errors.Is finds
+// the Err* sentinels as well as WasmErr.
+func (e *CompileError) Is(target error) bool {
+	return e.Cause != nil && errors.Is(e.Cause, target)
+}
+
+// As finds the first error in the classified cause's chain that matches
+// target, before WasmErr is unwrapped.
+func (e *CompileError) As(target any) bool {
+	return e.Cause != nil && errors.As(e.Cause, target)
+}
+
+var (
+	missingFontRe = regexp.MustCompile(`(?i)not loadable: Metric \(TFM\) file|The font "[^"]*" cannot be found|could not locate a virtual/physical font`)
+	outOfMemoryRe = regexp.MustCompile(`(?i)TeX capacity exceeded|memory allocation of \d+ bytes failed|out of memory`)
+	noFormatRe    = regexp.MustCompile(`(?i)(?:latex\.fmt|format file).*(?:not found|unable|cannot|can't)|(?:not find|unable to (?:open|load)|can't find) (?:the )?format`)
+)
+
+// runError builds the CompileError for a failed engine run. exitCode is the
+// engine's return value, or 0 if the call itself failed with wasmErr.
+func runError(ctx context.Context, exitCode int32, wasmErr error, logs string, diags []Diagnostic) *CompileError {
+	e := &CompileError{
+		ExitCode:    exitCode,
+		Logs:        logs,
+		WasmErr:     wasmErr,
+		Diagnostics: diags,
+	}
+	if wasmErr != nil {
+		e.ExitCode = 2
+		if cause := interruptCause(ctx, wasmErr); cause != nil {
+			e.Cause = cause
+			return e
+		}
+	} else if cause := context.Cause(ctx); errors.Is(cause, ErrLimitExceeded) {
+		// The engine gave up after a limit refused it a resource.
+		e.Cause = cause
+		return e
+	}
+	e.Cause = classifyLogs(logs, diags)
+	return e
+}
+
+// interruptCause reports whether err was caused by the context being done,
+// returning ErrCanceled, ErrTimeout or the exceeded resource limit if so.
+func interruptCause(ctx context.Context, err error) error {
+	// A tripped resource limit cancels the run's context with the limit's error.
+	if cause := context.Cause(ctx); errors.Is(cause, ErrLimitExceeded) {
+		return cause
+	}
+
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) {
+		switch exitErr.ExitCode() {
+		case sys.ExitCodeContextCanceled:
+			return ErrCanceled
+		case sys.ExitCodeDeadlineExceeded:
+			return ErrTimeout
+		}
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return ErrTimeout
+	case errors.Is(err, context.Canceled), errors.Is(ctx.Err(), context.Canceled):
+		return ErrCanceled
+	}
+	return nil
+}
+
+// classifyLogs derives the cause of a failed run from its diagnostics and
+// output, checking the most specific causes first.
+func classifyLogs(logs string, diags []Diagnostic) error {
+	var text strings.Builder
+	text.WriteString(logs)
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			text.WriteString("\n" + d.Message)
+		}
+	}
+	all := text.String()
+
+	switch {
+	case outOfMemoryRe.MatchString(all):
+		return ErrOutOfMemory
+	case noFormatRe.MatchString(all):
+		return ErrNoFormat
+	case missingFontRe.MatchString(all):
+		return ErrMissingFont
+	}
+	for _, d := range diags {
+		if d.Severity == SeverityError && d.Category == CategoryMissingFile {
+			return ErrMissingFile
+		}
+	}
+	return nil
+}
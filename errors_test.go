@@ -0,0 +1,102 @@
+package tecgonic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/tetratelabs/wazero/sys"
+)
+
+func TestRunErrorInterrupted(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		wasmErr error
+		want    error
+	}{
+		{"canceled", sys.NewExitError(sys.ExitCodeContextCanceled), ErrCanceled},
+		{"deadline", sys.NewExitError(sys.ExitCodeDeadlineExceeded), ErrTimeout},
+		{"wrapped deadline", fmt.Errorf("call: %w", context.DeadlineExceeded), ErrTimeout},
+	}
+	for _, tt := range tests {
+		err := runError(ctx, 0, tt.wasmErr, "", nil)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: runError = %v, want cause %v", tt.name, err, tt.want)
+		}
+		if err.IsPanic() {
+			t.Errorf("%s: IsPanic() = true for an interrupted run", tt.name)
+		}
+		if err.ExitCode != 2 {
+			t.Errorf("%s: ExitCode = %d, want 2", tt.name, err.ExitCode)
+		}
+		if errors.Unwrap(err) != tt.wasmErr {
+			t.Errorf("%s: errors.Unwrap = %v, want the wazero error", tt.name, errors.Unwrap(err))
+		}
+	}
+
+	trap := runError(ctx, 0, errors.New("wasm error: unreachable"), "", nil)
+	if !trap.IsPanic() || trap.Cause != nil {
+		t.Errorf("trap: IsPanic() = %v, Cause = %v; want true, nil", trap.IsPanic(), trap.Cause)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := runError(canceled, 0, errors.New("module closed"), "", nil); !err.IsCanceled() {
+		t.Errorf("canceled context: IsCanceled() = false (%v)", err)
+	}
+}
+
+func TestRunErrorFromLogs(t *testing.T) {
+	tests := []struct {
+		name string
+		logs string
+		want error
+	}{
+		{"missing file", "! LaTeX Error: File `fancy.sty' not found.\n", ErrMissingFile},
+		{"missing font package name", "! LaTeX Error: File `fontspec.sty' not found.\n", ErrMissingFile},
+		{"missing font", "! Font \\x=nosuchfont at 10pt not loadable: Metric (TFM) file or installed font not found.\n", ErrMissingFont},
+		{"fontspec", "! Package fontspec Error: The font \"Comic Neue\" cannot be found.\n", ErrMissingFont},
+		{"capacity", "! TeX capacity exceeded, sorry [main memory size=5000000].\n", ErrOutOfMemory},
+		{"allocation", "memory allocation of 1048576 bytes failed\n", ErrOutOfMemory},
+		{"format", "error: unable to load format file latex.fmt: not found\n", ErrNoFormat},
+		{"plain TeX error", "! Undefined control sequence.\nl.3 \\foo\n", nil},
+	}
+	for _, tt := range tests {
+		err := runError(context.Background(), 1, nil, tt.logs, ParseLog(tt.logs))
+		if tt.want == nil {
+			if err.Cause != nil {
+				t.Errorf("%s: Cause = %v, want nil", tt.name, err.Cause)
+			}
+			continue
+		}
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: Cause = %v, want %v", tt.name, err.Cause, tt.want)
+		}
+		if !err.IsTexError() {
+			t.Errorf("%s: IsTexError() = false", tt.name)
+		}
+	}
+}
+
+func TestCompileErrorChain(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(ErrMemoryLimit)
+	wasmErr := sys.NewExitError(sys.ExitCodeContextCanceled)
+
+	err := runError(ctx, 0, wasmErr, "", nil)
+	for _, target := range []error{wasmErr, ErrLimitExceeded, ErrMemoryLimit} {
+		if !errors.Is(err, target) {
+			t.Errorf("errors.Is(%v, %v) = false", err, target)
+		}
+	}
+	var exitErr *sys.ExitError
+	if !errors.As(err, &exitErr) || exitErr != wasmErr {
+		t.Errorf("errors.As did not find the wazero error")
+	}
+	if !err.IsLimitExceeded() || err.IsPanic() {
+		t.Errorf("IsLimitExceeded() = %v, IsPanic() = %v; want true, false", err.IsLimitExceeded(), err.IsPanic())
+	}
+}
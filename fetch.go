@@ -0,0 +1,236 @@
+package tecgonic
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// BundleFetcher retrieves bundle files for PrepareBundle, DownloadBundle and
+// OpenLazyBundle. HTTPFetcher is used by default; FSFetcher reads bundles from
+// a filesystem. Set another with WithFetcher.
+//
+// Errors matching fs.ErrNotExist, fs.ErrPermission or fs.ErrInvalid are taken
+// as permanent: the URL is not retried, though mirrors still are.
+type BundleFetcher interface {
+	// Open opens the bundle at url for reading from the start.
+	Open(ctx context.Context, url string) (*BundleStream, error)
+}
+
+// RangeFetcher is a BundleFetcher that can also read part of a bundle, which
+// resumes interrupted downloads and is required by OpenLazyBundle.
+type RangeFetcher interface {
+	BundleFetcher
+
+	// OpenRange opens length bytes of the bundle at url, starting at offset,
+	// or everything from offset if length is negative. If the range cannot be
+	// served, it may return the whole bundle instead, with Offset 0.
+	OpenRange(ctx context.Context, url string, offset, length int64) (*BundleStream, error)
+}
+
+// BundleStream is an open bundle, or part of one, returned by a BundleFetcher.
+type BundleStream struct {
+	io.ReadCloser
+
+	// Offset is the position in the bundle at which the stream starts.
+	Offset int64
+
+	// Size is the size of the whole bundle, -1 if unknown.
+	Size int64
+
+	// Version identifies the bundle's contents, such as an HTTP ETag, so a
+	// download is not resumed against a different bundle; empty if unknown.
+	Version string
+}
+
+// WithFetcher sets the BundleFetcher used to retrieve bundles, replacing the
+// default HTTPFetcher.
+func WithFetcher(f BundleFetcher) PrepareBundleOption {
+	return func(c *prepareBundleConfig) {
+		c.fetcher = f
+	}
+}
+
+// bundleFetcher returns the configured fetcher.
+func (c prepareBundleConfig) bundleFetcher() BundleFetcher {
+	if c.fetcher != nil {
+		return c.fetcher
+	}
+	return &HTTPFetcher{Client: c.client}
+}
+
+// HTTPFetcher fetches bundles with HTTP GET requests, using Range requests
+// for parts of a bundle.
+type HTTPFetcher struct {
+	// Client is the client used; nil means http.DefaultClient.
+	Client *http.Client
+}
+
+// Open implements BundleFetcher.
+func (h *HTTPFetcher) Open(ctx context.Context, url string) (*BundleStream, error) {
+	return h.OpenRange(ctx, url, 0, -1)
+}
+
+// OpenRange implements RangeFetcher.
+func (h *HTTPFetcher) OpenRange(ctx context.Context, url string, offset, length int64) (*BundleStream, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	switch {
+	case length >= 0:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &BundleStream{ReadCloser: resp.Body, Size: -1}
+	if tag := resp.Header.Get("ETag"); !strings.HasPrefix(tag, "W/") {
+		s.Version = tag
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		s.Size = resp.ContentLength
+		return s, nil
+	case http.StatusPartialContent:
+		start, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			_ = resp.Body.Close()
+			return nil, fmt.Errorf("%s: unexpected Content-Range %q", url, resp.Header.Get("Content-Range"))
+		}
+		s.Offset, s.Size = offset, size
+		return s, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		_ = resp.Body.Close()
+		// A resumed download may already be complete
+		if _, size, ok := parseContentRange(resp.Header.Get("Content-Range")); ok && size == offset {
+			return &BundleStream{ReadCloser: io.NopCloser(bytes.NewReader(nil)), Offset: offset, Size: size, Version: s.Version}, nil
+		}
+		return h.Open(ctx, url)
+	default:
+		_ = resp.Body.Close()
+		return nil, &httpStatusError{url: url, code: resp.StatusCode}
+	}
+}
+
+// httpStatusError is an unexpected HTTP status from a bundle fetch.
+type httpStatusError struct {
+	url  string
+	code int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("%s: HTTP %d", e.url, e.code)
+}
+
+// Unwrap maps client errors, other than timeouts and rate limiting, to the
+// fs errors BundleFetcher uses for permanent failures.
+func (e *httpStatusError) Unwrap() error {
+	switch {
+	case e.code == http.StatusNotFound || e.code == http.StatusGone:
+		return fs.ErrNotExist
+	case e.code == http.StatusUnauthorized || e.code == http.StatusForbidden:
+		return fs.ErrPermission
+	case e.code == http.StatusRequestTimeout || e.code == http.StatusTooManyRequests:
+		return nil
+	case e.code >= 400 && e.code < 500:
+		return fs.ErrInvalid
+	}
+	return nil
+}
+
+// parseContentRange parses "bytes start-end/size" or "bytes */size". start
+// is -1 in the latter form.
+func parseContentRange(s string) (start, size int64, ok bool) {
+	spec, found := strings.CutPrefix(s, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+	rng, sizeStr, found := strings.Cut(spec, "/")
+	if !found {
+		return 0, 0, false
+	}
+	size, err := strconv.ParseInt(sizeStr, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if rng == "*" {
+		return -1, size, true
+	}
+	first, _, found := strings.Cut(rng, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return start, size, true
+}
+
+// FSFetcher fetches bundles from a filesystem, such as a mounted artifact
+// store. URLs are slash-separated paths in FS; a leading slash is ignored.
+type FSFetcher struct {
+	FS fs.FS
+}
+
+// Open implements BundleFetcher.
+func (f *FSFetcher) Open(ctx context.Context, url string) (*BundleStream, error) {
+	return f.OpenRange(ctx, url, 0, -1)
+}
+
+// OpenRange implements RangeFetcher.
+func (f *FSFetcher) OpenRange(ctx context.Context, url string, offset, length int64) (*BundleStream, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	file, err := f.FS.Open(strings.TrimPrefix(url, "/"))
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if s, ok := file.(io.Seeker); ok {
+		_, err = s.Seek(offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, file, offset)
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("%s: seeking to %d: %w", url, offset, err)
+	}
+
+	var r io.Reader = file
+	if length >= 0 {
+		r = io.LimitReader(file, length)
+	}
+	return &BundleStream{
+		ReadCloser: readCloser{Reader: r, Closer: file},
+		Offset:     offset,
+		Size:       info.Size(),
+		Version:    fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano()),
+	}, nil
+}
+
+// readCloser combines a reader with the Closer of its source.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
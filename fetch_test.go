@@ -0,0 +1,117 @@
+package tecgonic
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFSFetcher(t *testing.T) {
+	f := &FSFetcher{FS: fstest.MapFS{"bundles/b.tar": {Data: []byte("0123456789")}}}
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, -1, "0123456789"},
+		{4, -1, "456789"},
+		{2, 3, "234"},
+	} {
+		s, err := f.OpenRange(ctx, "/bundles/b.tar", tc.offset, tc.length)
+		if err != nil {
+			t.Fatalf("OpenRange(%d, %d): %v", tc.offset, tc.length, err)
+		}
+		got, err := io.ReadAll(s)
+		_ = s.Close()
+		if err != nil || string(got) != tc.want || s.Offset != tc.offset || s.Size != 10 {
+			t.Errorf("OpenRange(%d, %d) = %q at %d of %d, %v; want %q", tc.offset, tc.length, got, s.Offset, s.Size, err, tc.want)
+		}
+	}
+
+	if _, err := f.Open(ctx, "missing.tar"); !permanentFetchError(err) {
+		t.Errorf("Open(missing) = %v, want a permanent error", err)
+	}
+}
+
+func TestPrepareBundleFSFetcher(t *testing.T) {
+	fsys := fstest.MapFS{"bundles/b.tar": {Data: testBundle(t, 120)}}
+	dest := filepath.Join(t.TempDir(), "bundle")
+
+	// A partial download of a different version is discarded.
+	if err := os.WriteFile(dest+downloadSuffix, []byte("stale"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dest+downloadSuffix+".partial", []byte("bundles/b.tar\n-1\nold"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := PrepareBundle(context.Background(), dest, "bundles/b.tar", false, WithFetcher(&FSFetcher{FS: fsys})); err != nil {
+		t.Fatalf("PrepareBundle: %v", err)
+	}
+	if _, err := VerifyBundle(dest); err != nil {
+		t.Errorf("VerifyBundle: %v", err)
+	}
+}
+
+// plainFetcher is a BundleFetcher without range support.
+type plainFetcher struct {
+	FSFetcher
+}
+
+func (f plainFetcher) Open(ctx context.Context, url string) (*BundleStream, error) {
+	return f.FSFetcher.Open(ctx, url)
+}
+
+func TestOpenLazyBundleNeedsRangeFetcher(t *testing.T) {
+	_, err := OpenLazyBundle(context.Background(), "b.tar", t.TempDir(), WithFetcher(plainFetcher{}))
+	if err == nil {
+		t.Error("OpenLazyBundle accepted a fetcher without range support")
+	}
+}
+
+func TestHTTPStatusErrorPermanent(t *testing.T) {
+	for code, want := range map[int]bool{
+		http.StatusNotFound:            true,
+		http.StatusForbidden:           true,
+		http.StatusBadRequest:          true,
+		http.StatusRequestTimeout:      false,
+		http.StatusTooManyRequests:     false,
+		http.StatusInternalServerError: false,
+		http.StatusBadGateway:          false,
+	} {
+		err := error(&httpStatusError{url: "u", code: code})
+		if got := permanentFetchError(err); got != want {
+			t.Errorf("HTTP %d permanent = %v, want %v", code, got, want)
+		}
+	}
+	if !errors.Is(&httpStatusError{code: http.StatusNotFound}, fs.ErrNotExist) {
+		t.Error("HTTP 404 does not match fs.ErrNotExist")
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		in          string
+		start, size int64
+		ok          bool
+	}{
+		{"bytes 100-199/1000", 100, 1000, true},
+		{"bytes */1000", -1, 1000, true},
+		{"bytes 100-199/*", 0, 0, false},
+		{"items 0-1/2", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, size, ok := parseContentRange(tt.in)
+		if start != tt.start || size != tt.size || ok != tt.ok {
+			t.Errorf("parseContentRange(%q) = %d, %d, %v; want %d, %d, %v", tt.in, start, size, ok, tt.start, tt.size, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package tecgonic
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mgilbir/tecgonic/wasm"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+)
+
+// ErrBudgetExceeded reports that a compilation used up the fuel budget set
+// with WithFuelBudget. It matches ErrLimitExceeded.
+var ErrBudgetExceeded = fmt.Errorf("%w: fuel budget (WithFuelBudget)", ErrLimitExceeded)
+
+// fuelKey is the context key under which a run's fuelMeter is stored.
+type fuelKey struct{}
+
+// fuelMeter counts the fuel a single engine run consumes. One unit of fuel is
+// one WebAssembly function call, so for a given module, bundle and input the
+// count is the same on every machine regardless of load.
+type fuelMeter struct {
+	budget  uint64
+	used    uint64
+	limiter *limiter
+}
+
+// consume charges one unit of fuel, tripping the budget once it is spent.
+// Engine runs are single-threaded, so no locking is needed.
+func (m *fuelMeter) consume() {
+	m.used++
+	if m.used == m.budget+1 {
+		m.limiter.trip(ErrBudgetExceeded)
+	}
+}
+
+// fuelListener charges the meter of the calling run on every function call.
+// A single instance is shared by all functions of the metered module.
+var fuelListener = experimental.FunctionListenerFunc(
+	func(ctx context.Context, _ api.Module, _ api.FunctionDefinition, _ []uint64, _ experimental.StackIterator) {
+		if m, ok := ctx.Value(fuelKey{}).(*fuelMeter); ok {
+			m.consume()
+		}
+	})
+
+// meteredModule returns the Tectonic module compiled with fuel
+// instrumentation. Instrumentation slows every call down, so it is compiled
+// separately, the first time a compilation asks for a fuel budget.
+func (c *Compiler) meteredModule(ctx context.Context) (wazero.CompiledModule, error) {
+	c.meteredOnce.Do(func() {
+		ctx := experimental.WithFunctionListenerFactory(context.WithoutCancel(ctx),
+			experimental.FunctionListenerFactoryFunc(func(api.FunctionDefinition) experimental.FunctionListener {
+				return fuelListener
+			}))
+		c.metered, c.meteredErr = c.runtime.CompileModule(ctx, wasm.TectonicWASM)
+		if c.meteredErr != nil {
+			c.meteredErr = fmt.Errorf("tecgonic: compiling metered WASM module: %w", c.meteredErr)
+		}
+	})
+	return c.metered, c.meteredErr
+}
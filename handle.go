@@ -0,0 +1,150 @@
+package tecgonic
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Bundle is an opened Tectonic bundle: an extracted bundle directory, such as
+// one prepared with PrepareBundle, or a bundle file opened with
+// OpenBundleArchive. Pass it to WithBundle or WithDefaultBundle to compile
+// with it, and log String to record exactly which bundle is in use.
+type Bundle struct {
+	path    string
+	fsys    fs.StatFS
+	archive BundleArchive // nil for a directory
+
+	version string
+	digest  string
+	names   []string // bundle files, sorted
+}
+
+// OpenBundle opens the bundle at path, which is either an extracted bundle
+// directory or a bundle file in itar or TTB format. Close the bundle when
+// done with it.
+func OpenBundle(path string) (*Bundle, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("tecgonic: opening bundle: %w: %w", err, ErrBundleMissing)
+	}
+
+	b := &Bundle{path: path}
+	if info.IsDir() {
+		b.fsys = os.DirFS(path).(fs.StatFS)
+	} else {
+		if b.archive, err = OpenBundleArchive(path); err != nil {
+			return nil, err
+		}
+		b.fsys = b.archive
+	}
+
+	entries, err := fs.ReadDir(b.fsys, ".")
+	if err != nil {
+		_ = b.Close()
+		return nil, fmt.Errorf("tecgonic: reading bundle %s: %w", path, err)
+	}
+	for _, e := range entries {
+		if e.Type().IsRegular() && !ignoredBundleFile(e.Name()) {
+			b.names = append(b.names, e.Name())
+		}
+	}
+	if len(b.names) == 0 {
+		_ = b.Close()
+		return nil, fmt.Errorf("tecgonic: %s holds no bundle files: %w", path, ErrBundleMissing)
+	}
+
+	if data, err := fs.ReadFile(b.fsys, "SVNREV"); err == nil {
+		b.version = strings.TrimSpace(string(data))
+	}
+	if data, err := fs.ReadFile(b.fsys, "SHA256SUM"); err == nil {
+		b.digest = strings.TrimSpace(string(data))
+	} else if ttb, ok := b.archive.(*TTB); ok {
+		b.digest = ttb.Digest()
+	}
+	return b, nil
+}
+
+// Close closes the bundle file of a bundle opened from one.
+func (b *Bundle) Close() error {
+	if b.archive == nil {
+		return nil
+	}
+	return b.archive.Close()
+}
+
+// Path returns the path the bundle was opened from.
+func (b *Bundle) Path() string { return b.path }
+
+// IsDir reports whether the bundle is an extracted directory rather than a
+// bundle file.
+func (b *Bundle) IsDir() bool { return b.archive == nil }
+
+// Version returns the bundle's TeX Live revision, read from its SVNREV file;
+// empty if it has none.
+func (b *Bundle) Version() string { return b.version }
+
+// Digest returns the digest the bundle was published with, read from its
+// SHA256SUM file or, for a TTB bundle without one, its header; empty if
+// unknown. See also VerifyBundle.
+func (b *Bundle) Digest() string { return b.digest }
+
+// Files returns the number of files in the bundle, not counting latex.fmt or
+// files tecgonic adds to bundle directories.
+func (b *Bundle) Files() int { return len(b.names) }
+
+// FormatPath returns the path of the bundle's latex.fmt format file.
+func (b *Bundle) FormatPath() string {
+	if b.archive != nil {
+		return b.archive.FormatPath()
+	}
+	return filepath.Join(b.path, "latex.fmt")
+}
+
+// HasFormat reports whether the format file has been generated; see
+// Compiler.GenerateFormat and Compiler.GenerateFormatFS.
+func (b *Bundle) HasFormat() bool {
+	_, err := os.Stat(b.FormatPath())
+	return err == nil
+}
+
+// FS returns a read-only view of the bundle's files, including latex.fmt
+// once it has been generated.
+func (b *Bundle) FS() fs.StatFS { return b.fsys }
+
+// String describes the bundle for logs.
+func (b *Bundle) String() string {
+	version, digest := b.version, b.digest
+	if version == "" {
+		version = "unknown"
+	}
+	if digest == "" {
+		digest = "unknown"
+	} else if len(digest) > 12 {
+		digest = digest[:12]
+	}
+	format := "with format"
+	if !b.HasFormat() {
+		format = "no format"
+	}
+	return fmt.Sprintf("%s (version %s, digest %s, %d files, %s)", b.path, version, digest, len(b.names), format)
+}
+
+// WithBundle uses b as the bundle for this compilation.
+func WithBundle(b *Bundle) CompileOption {
+	if b.IsDir() {
+		return WithBundleDir(b.path)
+	}
+	return WithBundleFS(b.fsys)
+}
+
+// WithDefaultBundle sets b as the default bundle for all compilations. The
+// bundle must stay open while the Compiler is in use.
+func WithDefaultBundle(b *Bundle) CompilerOption {
+	if b.IsDir() {
+		return WithDefaultBundleDir(b.path)
+	}
+	return WithDefaultBundleFS(b.fsys)
+}
@@ -0,0 +1,78 @@
+package tecgonic
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// handleFiles is a bundle of 120 files with an SVNREV.
+func handleFiles() map[string]string {
+	files := genFiles(119)
+	files["SVNREV"] = "12345\n"
+	return files
+}
+
+func TestOpenBundleDir(t *testing.T) {
+	f, err := os.Open(writeITar(t, handleFiles()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	dir := filepath.Join(t.TempDir(), "bundle")
+	if err := InstallBundle(context.Background(), dir, f); err != nil {
+		t.Fatalf("InstallBundle: %v", err)
+	}
+
+	b, err := OpenBundle(dir)
+	if err != nil {
+		t.Fatalf("OpenBundle: %v", err)
+	}
+	defer func() { _ = b.Close() }()
+
+	if !b.IsDir() || b.Path() != dir || b.Version() != "12345" || b.Files() != 120 || b.Digest() != "" {
+		t.Errorf("bundle = %s, dir %v, %d files, digest %q", b, b.IsDir(), b.Files(), b.Digest())
+	}
+	if b.HasFormat() {
+		t.Error("HasFormat before generating the format")
+	}
+	if err := os.WriteFile(b.FormatPath(), []byte("fmt"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if !b.HasFormat() {
+		t.Error("!HasFormat after writing latex.fmt")
+	}
+	if _, err := fs.Stat(b.FS(), "file007.sty"); err != nil {
+		t.Errorf("FS: %v", err)
+	}
+	if want := dir + " (version 12345, digest unknown, 120 files, with format)"; b.String() != want {
+		t.Errorf("String = %q, want %q", b.String(), want)
+	}
+}
+
+func TestOpenBundleFile(t *testing.T) {
+	b, err := OpenBundle(writeTTB(t, handleFiles()))
+	if err != nil {
+		t.Fatalf("OpenBundle: %v", err)
+	}
+	defer func() { _ = b.Close() }()
+
+	if b.IsDir() || b.Version() != "12345" || b.Files() != 120 || len(b.Digest()) != 64 {
+		t.Errorf("bundle = %s, dir %v", b, b.IsDir())
+	}
+	if !strings.HasSuffix(b.FormatPath(), ".fmt") || b.HasFormat() {
+		t.Errorf("FormatPath = %s, HasFormat %v", b.FormatPath(), b.HasFormat())
+	}
+}
+
+func TestOpenBundleMissing(t *testing.T) {
+	for _, path := range []string{filepath.Join(t.TempDir(), "missing"), t.TempDir()} {
+		if _, err := OpenBundle(path); !errors.Is(err, ErrBundleMissing) {
+			t.Errorf("OpenBundle(%s) = %v, want ErrBundleMissing", path, err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package tecgonic
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// instance is an instantiated engine module together with the workspace
+// mounted into it. Each instance runs at most one compilation.
+type instance struct {
+	mod    api.Module
+	ws     *workspace
+	stderr *switchWriter
+}
+
+// mounts describes the directories an instance is created with.
+type mounts struct {
+	input     fs.FS        // project tree mounted at /input, nil for the workspace's own
+	fontsDir  string       // host directory mounted at /fonts, empty for the workspace's own
+	bundleDir string       // host directory mounted read-only at /bundle
+	bundleFS  fs.FS        // filesystem mounted at /bundle instead of bundleDir, if non-nil
+	overlay   overlayIndex // files layered in front of the bundle, if any
+}
+
+// instantiate creates an instance of compiled with ws and m mounted. The
+// instance's stderr discards output until a writer is set.
+func (c *Compiler) instantiate(ctx context.Context, compiled wazero.CompiledModule, ws *workspace, m mounts) (*instance, error) {
+	stderr := &switchWriter{}
+
+	fsConfig := ws.mount(wazero.NewFSConfig(), m.input, m.fontsDir)
+	if bundle := m.bundleMount(); bundle != nil {
+		fsConfig = fsConfig.WithFSMount(bundle, "/bundle")
+	} else {
+		fsConfig = fsConfig.WithReadOnlyDirMount(m.bundleDir, "/bundle")
+	}
+
+	modConfig := wazero.NewModuleConfig().
+		WithName("").
+		WithStdout(io.Discard).
+		WithStderr(stderr).
+		WithFSConfig(fsConfig).
+		WithEnv("TECTONIC_FONT_DIR", "/fonts").
+		WithEnv("TECTONIC_CACHE_DIR", "/cache")
+
+	mod, err := c.runtime.InstantiateModule(ctx, compiled, modConfig)
+	if err != nil {
+		if cause := interruptCause(ctx, err); cause != nil {
+			return nil, &CompileError{ExitCode: 2, WasmErr: err, Cause: cause}
+		}
+		return nil, fmt.Errorf("tecgonic: instantiating module: %w", err)
+	}
+	return &instance{mod: mod, ws: ws, stderr: stderr}, nil
+}
+
+// close releases the module and the workspace.
+func (i *instance) close(ctx context.Context) {
+	_ = i.mod.Close(ctx)
+	i.ws.close()
+}
+
+// switchWriter forwards writes to a writer that can be set after the module
+// writing to it was instantiated, discarding them while none is set.
+type switchWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *switchWriter) set(w io.Writer) {
+	s.mu.Lock()
+	s.w = w
+	s.mu.Unlock()
+}
+
+func (s *switchWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w == nil {
+		return len(p), nil
+	}
+	return s.w.Write(p)
+}
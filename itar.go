@@ -0,0 +1,367 @@
+package tecgonic
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ITar is a Tectonic bundle in "itar" format served directly from the
+// archive file, without extracting it. It builds an index of the archive's
+// entries once and decompresses each entry when it is opened.
+//
+// ITar implements fs.FS and is safe for concurrent use, so it can be passed
+// to WithBundleFS or WithDefaultBundleFS. Entries are served flat at the root,
+// as PrepareBundle extracts them. The archive holds no format file: generate
+// one with Compiler.GenerateFormatFS and write it to FormatPath, from where it
+// is served as latex.fmt.
+type ITar struct {
+	archive
+}
+
+// archive serves the entries of a bundle archive file by random access.
+type archive struct {
+	f       *os.File
+	path    string
+	modTime time.Time
+	entries map[string]itarEntry
+}
+
+// itarEntry locates one archive member, possibly gzip-compressed, in the file.
+type itarEntry struct {
+	offset int64
+	length int64
+	size   int64 // uncompressed size, -1 if unknown
+}
+
+// OpenITar opens the itar bundle at path, e.g. one saved with DownloadBundle.
+// The index is read from path + ".index" if it is at least as new as the
+// archive; otherwise it is built by scanning the archive and saved there for
+// next time, if the directory is writable.
+func OpenITar(path string) (*ITar, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("tecgonic: opening itar bundle: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("tecgonic: opening itar bundle: %w", err)
+	}
+
+	b := &ITar{archive{f: f, path: path, modTime: info.ModTime()}}
+	indexPath := path + ".index"
+	if idx, err := os.Stat(indexPath); err == nil && !idx.ModTime().Before(info.ModTime()) {
+		if data, err := os.ReadFile(indexPath); err == nil {
+			b.entries, err = parseITarIndex(bytes.NewReader(data))
+			if err != nil {
+				b.entries = nil
+			}
+		}
+	}
+	if b.entries == nil {
+		if b.entries, err = scanITar(f); err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("tecgonic: indexing itar bundle: %w", err)
+		}
+		// The index is only a cache; failing to save it is not an error.
+		_ = os.WriteFile(indexPath, formatITarIndex(b.entries), 0o644)
+	}
+	return b, nil
+}
+
+// Close closes the archive file.
+func (b *archive) Close() error {
+	return b.f.Close()
+}
+
+// FormatPath returns the path of the format file served as latex.fmt.
+func (b *archive) FormatPath() string {
+	return b.path + ".fmt"
+}
+
+// Open implements fs.FS.
+func (b *archive) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &indexDir{fsys: b, modTime: b.modTime, names: sortedNames(b.entries)}, nil
+	}
+	e, ok := b.entries[name]
+	if !ok {
+		if name == "latex.fmt" {
+			return os.Open(b.FormatPath())
+		}
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	data, err := readITarEntry(io.NewSectionReader(b.f, e.offset, e.length))
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &memEntry{Reader: bytes.NewReader(data), info: entryInfo{name: name, size: int64(len(data)), modTime: b.modTime}}, nil
+}
+
+// Stat implements fs.StatFS. If the index does not record the size of a
+// compressed entry, it is read from the entry's gzip trailer, without
+// decompressing it.
+func (b *archive) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return entryInfo{name: ".", dir: true, modTime: b.modTime}, nil
+	}
+	e, ok := b.entries[name]
+	if !ok {
+		if name == "latex.fmt" {
+			return os.Stat(b.FormatPath())
+		}
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	size := e.size
+	if size < 0 {
+		var err error
+		if size, err = itarEntrySize(io.NewSectionReader(b.f, e.offset, e.length)); err != nil {
+			return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+		}
+	}
+	return entryInfo{name: name, size: size, modTime: b.modTime}, nil
+}
+
+// sortedNames returns the names in an index, sorted.
+func sortedNames(entries map[string]itarEntry) []string {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// scanITar builds the index of an itar archive by walking its tar headers.
+// Entry contents are skipped, not read.
+func scanITar(r io.ReadSeeker) (map[string]itarEntry, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	pr := &positionReader{r: r}
+	tr := tar.NewReader(pr)
+	entries := make(map[string]itarEntry)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Base(header.Name)
+		if _, dup := entries[name]; !dup {
+			entries[name] = itarEntry{offset: pr.pos, length: header.Size, size: -1}
+		}
+	}
+}
+
+// positionReader tracks the offset of an io.ReadSeeker, letting archive/tar
+// seek over entry contents while exposing where each entry starts.
+type positionReader struct {
+	r   io.ReadSeeker
+	pos int64
+}
+
+func (p *positionReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.pos += int64(n)
+	return n, err
+}
+
+func (p *positionReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := p.r.Seek(offset, whence)
+	if err == nil {
+		p.pos = pos
+	}
+	return pos, err
+}
+
+// parseITarIndex reads an itar index, one "name offset length" line per
+// entry, the format Tectonic publishes alongside its bundles.
+func parseITarIndex(r io.Reader) (map[string]itarEntry, error) {
+	entries := make(map[string]itarEntry)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("malformed index line %q", line)
+		}
+		offset, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed index line %q", line)
+		}
+		length, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed index line %q", line)
+		}
+		entries[fields[0]] = itarEntry{offset: offset, length: length, size: -1}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("empty index")
+	}
+	return entries, nil
+}
+
+// formatITarIndex writes entries in the format read by parseITarIndex.
+func formatITarIndex(entries map[string]itarEntry) []byte {
+	var buf bytes.Buffer
+	for _, name := range sortedNames(entries) {
+		e := entries[name]
+		fmt.Fprintf(&buf, "%s %d %d\n", name, e.offset, e.length)
+	}
+	return buf.Bytes()
+}
+
+// gzipMagic starts every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// readITarEntry returns the contents of an archive member, decompressing it
+// if it is gzipped. Metadata entries such as SVNREV are stored uncompressed.
+func readITarEntry(r io.Reader) ([]byte, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(raw, gzipMagic) {
+		return raw, nil
+	}
+	gr, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = gr.Close() }()
+	return io.ReadAll(gr)
+}
+
+// itarEntrySize returns the uncompressed size of an archive member. For gzip
+// members this is the ISIZE trailer, which is exact for files under 4 GiB.
+func itarEntrySize(r *io.SectionReader) (int64, error) {
+	var magic [2]byte
+	if _, err := r.ReadAt(magic[:], 0); err != nil {
+		if err == io.EOF {
+			return r.Size(), nil
+		}
+		return 0, err
+	}
+	if !bytes.Equal(magic[:], gzipMagic) || r.Size() < 18 {
+		return r.Size(), nil
+	}
+	var trailer [4]byte
+	if _, err := r.ReadAt(trailer[:], r.Size()-4); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint32(trailer[:])), nil
+}
+
+// memEntry is an open, fully decompressed bundle file.
+type memEntry struct {
+	*bytes.Reader
+	info entryInfo
+}
+
+func (f *memEntry) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memEntry) Close() error               { return nil }
+
+// entryInfo describes a bundle file or the bundle's root directory.
+type entryInfo struct {
+	name    string
+	size    int64
+	dir     bool
+	modTime time.Time
+}
+
+func (i entryInfo) Name() string       { return i.name }
+func (i entryInfo) Size() int64        { return i.size }
+func (i entryInfo) ModTime() time.Time { return i.modTime }
+func (i entryInfo) IsDir() bool        { return i.dir }
+func (i entryInfo) Sys() any           { return nil }
+
+func (i entryInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+// indexDir is the open root directory of a bundle served from an index.
+type indexDir struct {
+	fsys    fs.StatFS
+	modTime time.Time
+	names   []string // names left to list, sorted
+}
+
+func (d *indexDir) Stat() (fs.FileInfo, error) {
+	return entryInfo{name: ".", dir: true, modTime: d.modTime}, nil
+}
+
+func (d *indexDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: ".", Err: errors.New("is a directory")}
+}
+
+func (d *indexDir) Close() error { return nil }
+
+func (d *indexDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n > 0 && len(d.names) == 0 {
+		return nil, io.EOF
+	}
+	if n <= 0 || n > len(d.names) {
+		n = len(d.names)
+	}
+	out := make([]fs.DirEntry, 0, n)
+	for _, name := range d.names[:n] {
+		out = append(out, fs.FileInfoToDirEntry(lazyInfo{fsys: d.fsys, name: name, modTime: d.modTime}))
+	}
+	d.names = d.names[n:]
+	return out, nil
+}
+
+// lazyInfo defers the size lookup of a listed entry until it is needed.
+type lazyInfo struct {
+	fsys    fs.StatFS
+	name    string
+	modTime time.Time
+}
+
+func (i lazyInfo) Name() string       { return i.name }
+func (i lazyInfo) ModTime() time.Time { return i.modTime }
+func (i lazyInfo) IsDir() bool        { return false }
+func (i lazyInfo) Mode() fs.FileMode  { return 0o444 }
+func (i lazyInfo) Sys() any           { return nil }
+
+func (i lazyInfo) Size() int64 {
+	info, err := i.fsys.Stat(i.name)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
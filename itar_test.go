@@ -0,0 +1,187 @@
+package tecgonic
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+// writeITar writes an itar archive holding files in name order,
+// gzip-compressing every entry except SVNREV as real bundles do, and returns
+// its path.
+func writeITar(t testing.TB, files map[string]string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		data := []byte(files[name])
+		if name != "SVNREV" {
+			var gz bytes.Buffer
+			gw := gzip.NewWriter(&gz)
+			_, _ = gw.Write(data)
+			_ = gw.Close()
+			data = gz.Bytes()
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "bundle.tar")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// itarData returns an itar archive holding files, as written by writeITar.
+func itarData(t testing.TB, files map[string]string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(writeITar(t, files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+var itarFiles = map[string]string{
+	"SVNREV":      "12345\n",
+	"article.cls": "% article class\n\\endinput\n",
+	"SHA256SUM":   "deadbeef\n",
+}
+
+func TestITar(t *testing.T) {
+	path := writeITar(t, itarFiles)
+	b, err := OpenITar(path)
+	if err != nil {
+		t.Fatalf("OpenITar: %v", err)
+	}
+	defer func() { _ = b.Close() }()
+
+	for name, want := range itarFiles {
+		got, err := fs.ReadFile(b, name)
+		if err != nil {
+			t.Fatalf("ReadFile(%s): %v", name, err)
+		}
+		if string(got) != want {
+			t.Errorf("ReadFile(%s) = %q, want %q", name, got, want)
+		}
+		info, err := fs.Stat(b, name)
+		if err != nil {
+			t.Fatalf("Stat(%s): %v", name, err)
+		}
+		if info.Size() != int64(len(want)) {
+			t.Errorf("Stat(%s).Size = %d, want %d", name, info.Size(), len(want))
+		}
+	}
+
+	if err := fstest.TestFS(b, "SVNREV", "article.cls", "SHA256SUM"); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := fs.Stat(b, "latex.fmt"); err == nil {
+		t.Error("latex.fmt exists before a format was written")
+	}
+	if err := os.WriteFile(b.FormatPath(), []byte("format"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := fs.ReadFile(b, "latex.fmt"); err != nil || string(got) != "format" {
+		t.Errorf("ReadFile(latex.fmt) = %q, %v", got, err)
+	}
+}
+
+func TestITarIndexReused(t *testing.T) {
+	path := writeITar(t, itarFiles)
+	b, err := OpenITar(path)
+	if err != nil {
+		t.Fatalf("OpenITar: %v", err)
+	}
+	_ = b.Close()
+
+	index, err := os.ReadFile(path + ".index")
+	if err != nil {
+		t.Fatalf("index not saved: %v", err)
+	}
+	entries, err := parseITarIndex(bytes.NewReader(index))
+	if err != nil {
+		t.Fatalf("parseITarIndex: %v", err)
+	}
+	if len(entries) != len(itarFiles) {
+		t.Errorf("index has %d entries, want %d", len(entries), len(itarFiles))
+	}
+
+	// Drop an entry from the saved index; a reopened bundle must trust it.
+	delete(entries, "SVNREV")
+	if err := os.WriteFile(path+".index", formatITarIndex(entries), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	b, err = OpenITar(path)
+	if err != nil {
+		t.Fatalf("OpenITar: %v", err)
+	}
+	defer func() { _ = b.Close() }()
+	if _, err := fs.Stat(b, "SVNREV"); err == nil {
+		t.Error("reopened bundle rescanned the archive instead of using its index")
+	}
+}
+
+// TestDuplicateBaseNames checks that the first of several entries with the
+// same base name is served and extracted, in both bundle formats.
+func TestDuplicateBaseNames(t *testing.T) {
+	files := genFiles(120)
+	files["a/dup.sty"] = "first"
+	files["b/dup.sty"] = "second"
+
+	for _, tc := range []struct {
+		name string
+		path string
+	}{
+		{"itar", writeITar(t, files)},
+		{"ttb", writeTTB(t, files)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := OpenBundleArchive(tc.path)
+			if err != nil {
+				t.Fatalf("OpenBundleArchive: %v", err)
+			}
+			defer func() { _ = b.Close() }()
+			if got, err := fs.ReadFile(b, "dup.sty"); err != nil || string(got) != "first" {
+				t.Errorf("ReadFile(dup.sty) = %q, %v; want the first entry", got, err)
+			}
+
+			f, err := os.Open(tc.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = f.Close() }()
+			dest := filepath.Join(t.TempDir(), "bundle")
+			if err := InstallBundle(context.Background(), dest, f); err != nil {
+				t.Fatalf("InstallBundle: %v", err)
+			}
+			if got, err := os.ReadFile(filepath.Join(dest, "dup.sty")); err != nil || string(got) != "first" {
+				t.Errorf("extracted dup.sty = %q, %v; want the first entry", got, err)
+			}
+		})
+	}
+}
+
+func TestParseITarIndexMalformed(t *testing.T) {
+	for _, index := range []string{"", "name 1\n", "name x 2\n", "name 1 y\n"} {
+		if _, err := parseITarIndex(bytes.NewReader([]byte(index))); err == nil {
+			t.Errorf("parseITarIndex(%q) succeeded", index)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package tecgonic
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LazyBundle is a Tectonic bundle fetched on demand over HTTP, the way
+// upstream Tectonic uses its bundles. Opening it downloads only the bundle's
+// index; each file is fetched with an HTTP Range request the first time the
+// engine opens it, and kept in a local cache directory for later runs.
+//
+// LazyBundle implements fs.FS and is safe for concurrent use, so it can be
+// passed to WithBundleFS or WithDefaultBundleFS. Like ITar, it serves the
+// format file from FormatPath as latex.fmt.
+type LazyBundle struct {
+	url      string
+	cacheDir string // cache of this bundle, under the cacheDir given to OpenLazyBundle
+	fetcher  RangeFetcher
+	timeout  time.Duration
+	progress ProgressFunc
+	entries  map[string]itarEntry
+	modTime  time.Time
+
+	mu       sync.Mutex
+	inflight map[string]*lazyFetch
+}
+
+// lazyFetch is a download of one entry that concurrent opens wait on.
+type lazyFetch struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// defaultFetchTimeout bounds each file a LazyBundle fetches.
+const defaultFetchTimeout = time.Minute
+
+// WithFetchTimeout bounds each file a LazyBundle fetches, in place of the
+// default of one minute; 0 means no limit. Files are fetched while the engine
+// runs, where neither the Compile context nor WithTimeLimit can stop a stalled
+// fetch.
+func WithFetchTimeout(d time.Duration) PrepareBundleOption {
+	return func(c *prepareBundleConfig) {
+		c.fetchTimeout = d
+	}
+}
+
+// OpenLazyBundle opens the itar bundle at bundleURL for on-demand access,
+// caching its index and fetched files in a subdirectory of cacheDir named
+// after bundleURL, so bundles from different URLs can share cacheDir. The
+// index is downloaded from bundleURL + ".index.gz" unless the cache already
+// holds it. The server must support HTTP Range requests; a fetcher set with
+// WithFetcher must be a RangeFetcher. WithProgress reports each fetched file
+// and WithFetchTimeout bounds each fetch.
+func OpenLazyBundle(ctx context.Context, bundleURL, cacheDir string, opts ...PrepareBundleOption) (*LazyBundle, error) {
+	cfg := prepareBundleConfig{fetchTimeout: defaultFetchTimeout}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	fetcher, ok := cfg.bundleFetcher().(RangeFetcher)
+	if !ok {
+		return nil, fmt.Errorf("tecgonic: lazy bundles need a RangeFetcher, got %T", cfg.bundleFetcher())
+	}
+
+	if bundleURL == "" {
+		bundleURL = DefaultBundleURL
+	}
+	cacheDir = filepath.Join(cacheDir, lazyCacheKey(bundleURL))
+
+	if err := os.MkdirAll(filepath.Join(cacheDir, "files"), 0o755); err != nil {
+		return nil, fmt.Errorf("tecgonic: creating bundle cache dir: %w", err)
+	}
+
+	indexPath := filepath.Join(cacheDir, "index")
+	index, err := os.ReadFile(indexPath)
+	if err != nil {
+		if index, err = fetchLazyIndex(ctx, fetcher, bundleURL+".index.gz"); err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(indexPath, index); err != nil {
+			return nil, fmt.Errorf("tecgonic: saving bundle index: %w", err)
+		}
+	}
+	entries, err := parseITarIndex(bytes.NewReader(index))
+	if err != nil {
+		return nil, fmt.Errorf("tecgonic: parsing bundle index: %w", err)
+	}
+	info, err := os.Stat(indexPath)
+	if err != nil {
+		return nil, fmt.Errorf("tecgonic: reading bundle index: %w", err)
+	}
+
+	return &LazyBundle{
+		url:      bundleURL,
+		cacheDir: cacheDir,
+		fetcher:  fetcher,
+		timeout:  cfg.fetchTimeout,
+		progress: cfg.progress,
+		entries:  entries,
+		modTime:  info.ModTime(),
+		inflight: make(map[string]*lazyFetch),
+	}, nil
+}
+
+// lazyCacheKey names the cache directory of the bundle at url.
+func lazyCacheKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:8])
+}
+
+// fetchLazyIndex downloads and decompresses a bundle index.
+func fetchLazyIndex(ctx context.Context, fetcher BundleFetcher, indexURL string) ([]byte, error) {
+	s, err := fetcher.Open(ctx, indexURL)
+	if err != nil {
+		return nil, fmt.Errorf("tecgonic: downloading bundle index: %w", err)
+	}
+	defer func() { _ = s.Close() }()
+	gr, err := gzip.NewReader(s)
+	if err != nil {
+		return nil, fmt.Errorf("tecgonic: downloading bundle index: %w", err)
+	}
+	defer func() { _ = gr.Close() }()
+	index, err := io.ReadAll(gr)
+	if err != nil {
+		return nil, fmt.Errorf("tecgonic: downloading bundle index: %w", err)
+	}
+	return index, nil
+}
+
+// FormatPath returns the path of the format file served as latex.fmt.
+func (b *LazyBundle) FormatPath() string {
+	return filepath.Join(b.cacheDir, "latex.fmt")
+}
+
+// Open implements fs.FS, fetching the file if it is not cached yet.
+func (b *LazyBundle) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &indexDir{fsys: b, modTime: b.modTime, names: sortedNames(b.entries)}, nil
+	}
+	e, ok := b.entries[name]
+	if !ok {
+		if name == "latex.fmt" {
+			return os.Open(b.FormatPath())
+		}
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if f, err := os.Open(b.cachePath(name)); err == nil {
+		return f, nil
+	}
+	data, err := b.fetch(name, e)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &memEntry{Reader: bytes.NewReader(data), info: entryInfo{name: name, size: int64(len(data)), modTime: b.modTime}}, nil
+}
+
+// Stat implements fs.StatFS. The size of a file is only known once it has
+// been fetched, so stat fetches it too.
+func (b *LazyBundle) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return entryInfo{name: ".", dir: true, modTime: b.modTime}, nil
+	}
+	e, ok := b.entries[name]
+	if !ok {
+		if name == "latex.fmt" {
+			return os.Stat(b.FormatPath())
+		}
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	if info, err := os.Stat(b.cachePath(name)); err == nil {
+		return info, nil
+	}
+	data, err := b.fetch(name, e)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return entryInfo{name: name, size: int64(len(data)), modTime: b.modTime}, nil
+}
+
+func (b *LazyBundle) cachePath(name string) string {
+	return filepath.Join(b.cacheDir, "files", name)
+}
+
+// fetch downloads an entry and stores it in the cache. Concurrent fetches of
+// the same entry share one download.
+func (b *LazyBundle) fetch(name string, e itarEntry) ([]byte, error) {
+	b.mu.Lock()
+	if f, ok := b.inflight[name]; ok {
+		b.mu.Unlock()
+		<-f.done
+		return f.data, f.err
+	}
+	f := &lazyFetch{done: make(chan struct{})}
+	b.inflight[name] = f
+	b.mu.Unlock()
+
+	f.data, f.err = b.download(e)
+	if f.err == nil {
+		// The cache only saves later downloads; failing to write it is not an error.
+		_ = writeFileAtomic(b.cachePath(name), f.data)
+		n := int64(len(f.data))
+		b.progress.report(ProgressEvent{Phase: PhaseFetch, Entry: name, Bytes: n, TotalBytes: n, Done: true})
+	}
+
+	b.mu.Lock()
+	delete(b.inflight, name)
+	b.mu.Unlock()
+	close(f.done)
+	return f.data, f.err
+}
+
+// download fetches and decompresses one entry with a range request.
+func (b *LazyBundle) download(e itarEntry) ([]byte, error) {
+	ctx := context.Background()
+	if b.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.timeout)
+		defer cancel()
+	}
+	s, err := b.fetcher.OpenRange(ctx, b.url, e.offset, e.length)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = s.Close() }()
+	if s.Offset != e.offset {
+		return nil, fmt.Errorf("fetching bundle range: got offset %d (server must support range requests)", s.Offset)
+	}
+	raw, err := io.ReadAll(io.LimitReader(s, e.length))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(raw)) != e.length {
+		return nil, fmt.Errorf("fetching bundle range: got %d bytes, want %d", len(raw), e.length)
+	}
+	return readITarEntry(bytes.NewReader(raw))
+}
+
+// writeFileAtomic writes data to path through a temporary file in the same
+// directory, so readers never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
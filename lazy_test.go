@@ -0,0 +1,203 @@
+package tecgonic
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serveITar serves the itar archive at path and its gzipped index the way
+// the Tectonic bundle server does, counting the requests for each.
+func serveITar(t *testing.T, path string) (srv *httptest.Server, archiveHits, indexHits *atomic.Int64) {
+	t.Helper()
+	archive, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := scanITar(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index bytes.Buffer
+	gw := gzip.NewWriter(&index)
+	_, _ = gw.Write(formatITarIndex(entries))
+	_ = gw.Close()
+
+	archiveHits, indexHits = new(atomic.Int64), new(atomic.Int64)
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bundle.tar":
+			archiveHits.Add(1)
+			http.ServeContent(w, r, "bundle.tar", time.Time{}, bytes.NewReader(archive))
+		case "/bundle.tar.index.gz":
+			indexHits.Add(1)
+			_, _ = w.Write(index.Bytes())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, archiveHits, indexHits
+}
+
+func TestLazyBundle(t *testing.T) {
+	srv, archiveHits, indexHits := serveITar(t, writeITar(t, itarFiles))
+	ctx := context.Background()
+	cacheDir := t.TempDir()
+
+	b, err := OpenLazyBundle(ctx, srv.URL+"/bundle.tar", cacheDir)
+	if err != nil {
+		t.Fatalf("OpenLazyBundle: %v", err)
+	}
+	if archiveHits.Load() != 0 {
+		t.Fatal("opening the bundle fetched archive contents")
+	}
+
+	got, err := fs.ReadFile(b, "article.cls")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(got) != itarFiles["article.cls"] {
+		t.Errorf("ReadFile = %q, want %q", got, itarFiles["article.cls"])
+	}
+	if n := archiveHits.Load(); n != 1 {
+		t.Errorf("archive requests = %d, want 1", n)
+	}
+
+	// A second open is served from the cache.
+	if _, err := fs.ReadFile(b, "article.cls"); err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if n := archiveHits.Load(); n != 1 {
+		t.Errorf("archive requests after cached read = %d, want 1", n)
+	}
+
+	if _, err := b.Open("missing.sty"); err == nil {
+		t.Error("Open of a file not in the index succeeded")
+	}
+
+	// Reopening the bundle reuses the cached index.
+	b, err = OpenLazyBundle(ctx, srv.URL+"/bundle.tar", cacheDir)
+	if err != nil {
+		t.Fatalf("OpenLazyBundle: %v", err)
+	}
+	if n := indexHits.Load(); n != 1 {
+		t.Errorf("index requests = %d, want 1", n)
+	}
+	if got, err := fs.ReadFile(b, "SVNREV"); err != nil || string(got) != itarFiles["SVNREV"] {
+		t.Errorf("ReadFile(SVNREV) = %q, %v", got, err)
+	}
+}
+
+func TestLazyBundleConcurrentFetch(t *testing.T) {
+	srv, archiveHits, _ := serveITar(t, writeITar(t, itarFiles))
+
+	b, err := OpenLazyBundle(context.Background(), srv.URL+"/bundle.tar", t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenLazyBundle: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := fs.ReadFile(b, "SHA256SUM"); err != nil {
+				t.Errorf("ReadFile: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := archiveHits.Load(); n > 8 || n < 1 {
+		t.Errorf("archive requests = %d", n)
+	}
+}
+
+func TestLazyBundleNoRangeSupport(t *testing.T) {
+	path := writeITar(t, itarFiles)
+	srv, _, _ := serveITar(t, path)
+	archive, _ := os.ReadFile(path)
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bundle.tar" {
+			_, _ = w.Write(archive) // ignores Range
+			return
+		}
+		http.Redirect(w, r, srv.URL+r.URL.Path, http.StatusFound)
+	}))
+	defer plain.Close()
+
+	b, err := OpenLazyBundle(context.Background(), plain.URL+"/bundle.tar", t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenLazyBundle: %v", err)
+	}
+	if _, err := fs.ReadFile(b, "article.cls"); err == nil {
+		t.Error("ReadFile succeeded against a server without range support")
+	}
+}
+
+func TestLazyBundleCacheByURL(t *testing.T) {
+	srv1, _, _ := serveITar(t, writeITar(t, itarFiles))
+	srv2, _, indexHits := serveITar(t, writeITar(t, map[string]string{"article.cls": "% other bundle\n"}))
+	ctx := context.Background()
+	cacheDir := t.TempDir()
+
+	b1, err := OpenLazyBundle(ctx, srv1.URL+"/bundle.tar", cacheDir)
+	if err != nil {
+		t.Fatalf("OpenLazyBundle: %v", err)
+	}
+	if _, err := fs.ReadFile(b1, "article.cls"); err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	b2, err := OpenLazyBundle(ctx, srv2.URL+"/bundle.tar", cacheDir)
+	if err != nil {
+		t.Fatalf("OpenLazyBundle: %v", err)
+	}
+	if n := indexHits.Load(); n != 1 {
+		t.Errorf("index requests for the second bundle = %d, want 1", n)
+	}
+	if got, err := fs.ReadFile(b2, "article.cls"); err != nil || string(got) != "% other bundle\n" {
+		t.Errorf("ReadFile = %q, %v; served from the other bundle's cache", got, err)
+	}
+	if b1.FormatPath() == b2.FormatPath() {
+		t.Errorf("both bundles use format %s", b1.FormatPath())
+	}
+}
+
+func TestLazyBundleFetchTimeout(t *testing.T) {
+	srv, _, _ := serveITar(t, writeITar(t, itarFiles))
+	stall := make(chan struct{})
+	defer close(stall)
+	stalled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bundle.tar" {
+			select {
+			case <-stall:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		http.Redirect(w, r, srv.URL+r.URL.Path, http.StatusFound)
+	}))
+	defer stalled.Close()
+
+	b, err := OpenLazyBundle(context.Background(), stalled.URL+"/bundle.tar", t.TempDir(), WithFetchTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("OpenLazyBundle: %v", err)
+	}
+	start := time.Now()
+	if _, err := b.Open("article.cls"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Open = %v, want context.DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Open took %v", d)
+	}
+}
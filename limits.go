@@ -0,0 +1,207 @@
+package tecgonic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tetratelabs/wazero/experimental"
+	experimentalsys "github.com/tetratelabs/wazero/experimental/sys"
+)
+
+// ErrLimitExceeded is matched by every error reporting that a compilation
+// exceeded one of the resource limits set with its CompileOptions.
+var ErrLimitExceeded = errors.New("resource limit exceeded")
+
+// Specific resource limit errors, each matching ErrLimitExceeded.
+var (
+	ErrMemoryLimit  = fmt.Errorf("%w: memory (WithMaxMemory)", ErrLimitExceeded)
+	ErrTimeLimit    = fmt.Errorf("%w: time (WithTimeLimit)", ErrLimitExceeded)
+	ErrPDFTooLarge  = fmt.Errorf("%w: PDF size (WithMaxPDFSize)", ErrLimitExceeded)
+	ErrTooManyFiles = fmt.Errorf("%w: output files (WithMaxOutputFiles)", ErrLimitExceeded)
+	ErrLogLimit     = fmt.Errorf("%w: log size (WithMaxLogSize)", ErrLimitExceeded)
+)
+
+// resourceLimits holds the per-compilation limits set with CompileOptions.
+// Zero values mean no limit.
+type resourceLimits struct {
+	maxMemory      uint64
+	timeLimit      time.Duration
+	maxPDFSize     int64
+	maxOutputFiles int
+	maxLogSize     int
+	fuelBudget     uint64
+}
+
+// limiter enforces resourceLimits during a single engine run. The first limit
+// to be exceeded cancels the run's context with that limit's error as cause,
+// which stops the engine.
+type limiter struct {
+	ctx     context.Context
+	limits  resourceLimits
+	cancel  context.CancelCauseFunc
+	release context.CancelFunc
+	fuel    *fuelMeter
+}
+
+// newLimiter derives the context the engine runs under from ctx.
+func newLimiter(ctx context.Context, limits resourceLimits) (context.Context, *limiter) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	l := &limiter{limits: limits, cancel: cancel, release: func() {}}
+	if limits.timeLimit > 0 {
+		ctx, l.release = context.WithTimeoutCause(ctx, limits.timeLimit, ErrTimeLimit)
+	}
+	if limits.maxMemory > 0 {
+		ctx = experimental.WithMemoryAllocator(ctx, experimental.MemoryAllocatorFunc(l.allocate))
+	}
+	if limits.fuelBudget > 0 {
+		l.fuel = &fuelMeter{budget: limits.fuelBudget, limiter: l}
+		ctx = context.WithValue(ctx, fuelKey{}, l.fuel)
+	}
+	l.ctx = ctx
+	return ctx, l
+}
+
+// trip stops the run because err's limit was exceeded.
+func (l *limiter) trip(err error) {
+	l.cancel(err)
+}
+
+// close releases the run's context.
+func (l *limiter) close() {
+	l.release()
+	l.cancel(nil)
+}
+
+// fuelUsed returns the fuel consumed so far, or 0 if the run is not metered.
+func (l *limiter) fuelUsed() uint64 {
+	if l.fuel == nil {
+		return 0
+	}
+	return l.fuel.used
+}
+
+// allocate implements experimental.MemoryAllocator, refusing to grow linear
+// memory beyond maxMemory.
+func (l *limiter) allocate(capacity, maxSize uint64) experimental.LinearMemory {
+	return &limitedMemory{limiter: l, max: min(maxSize, l.limits.maxMemory), initialCap: capacity}
+}
+
+// limitedMemory is a linear memory that fails to grow past max bytes.
+type limitedMemory struct {
+	limiter    *limiter
+	max        uint64
+	initialCap uint64
+	buf        []byte
+}
+
+func (m *limitedMemory) Reallocate(size uint64) []byte {
+	if size > m.max {
+		m.limiter.trip(ErrMemoryLimit)
+		if m.buf != nil {
+			return nil
+		}
+		// The module's declared minimum must always be allocated; the tripped
+		// limit stops the run before it starts.
+	}
+	if size <= uint64(cap(m.buf)) {
+		m.buf = m.buf[:size]
+		return m.buf
+	}
+	newCap := min(max(m.initialCap, 2*uint64(cap(m.buf))), m.max)
+	buf := make([]byte, size, max(size, newCap))
+	copy(buf, m.buf)
+	m.buf = buf
+	return buf
+}
+
+func (m *limitedMemory) Free() {
+	m.buf = nil
+}
+
+// wrapOutput limits the number of files the engine may create in its output
+// directory.
+func (l *limiter) wrapOutput(fsys experimentalsys.FS) experimentalsys.FS {
+	if l.limits.maxOutputFiles <= 0 {
+		return fsys
+	}
+	f := &createLimitFS{FS: fsys, limiter: l}
+	f.remaining.Store(int64(l.limits.maxOutputFiles))
+	return f
+}
+
+// createLimitFS refuses to create more files than its remaining budget.
+type createLimitFS struct {
+	experimentalsys.FS
+	limiter   *limiter
+	remaining atomic.Int64
+}
+
+func (f *createLimitFS) OpenFile(path string, flag experimentalsys.Oflag, perm fs.FileMode) (experimentalsys.File, experimentalsys.Errno) {
+	if flag&experimentalsys.O_CREAT != 0 {
+		if _, errno := f.FS.Stat(path); errno == experimentalsys.ENOENT {
+			if f.remaining.Add(-1) < 0 {
+				f.limiter.trip(ErrTooManyFiles)
+				return nil, experimentalsys.EPERM
+			}
+		}
+	}
+	return f.FS.OpenFile(path, flag, perm)
+}
+
+// wrapLog caps the number of bytes of engine output captured in w.
+func (l *limiter) wrapLog(w io.Writer) io.Writer {
+	if l.limits.maxLogSize <= 0 {
+		return w
+	}
+	return &cappedWriter{w: w, remaining: l.limits.maxLogSize, limiter: l}
+}
+
+// cappedWriter passes writes through until its budget is spent, then trips
+// the log limit and discards the rest.
+type cappedWriter struct {
+	mu        sync.Mutex
+	w         io.Writer
+	remaining int
+	limiter   *limiter
+}
+
+func (c *cappedWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(p) > c.remaining {
+		if c.remaining > 0 {
+			_, _ = c.w.Write(p[:c.remaining])
+			c.remaining = 0
+		}
+		c.limiter.trip(ErrLogLimit)
+		return len(p), nil
+	}
+	c.remaining -= len(p)
+	return c.w.Write(p)
+}
+
+// checkOutput reports a limit tripped during the run, then enforces the
+// limits that apply to the finished output.
+func (l *limiter) checkOutput(ws *workspace) error {
+	if cause := context.Cause(l.ctx); errors.Is(cause, ErrLimitExceeded) {
+		return cause
+	}
+	if l.limits.maxOutputFiles > 0 {
+		names, err := ws.readDir("output")
+		if err == nil && len(names) > l.limits.maxOutputFiles {
+			return ErrTooManyFiles
+		}
+	}
+	if l.limits.maxPDFSize > 0 {
+		if size, err := ws.fileSize("output", "input.pdf"); err == nil && size > l.limits.maxPDFSize {
+			return ErrPDFTooLarge
+		}
+	}
+	return nil
+}
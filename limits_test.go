@@ -0,0 +1,151 @@
+package tecgonic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	experimentalsys "github.com/tetratelabs/wazero/experimental/sys"
+)
+
+func TestLimitErrors(t *testing.T) {
+	for _, err := range []error{ErrMemoryLimit, ErrTimeLimit, ErrPDFTooLarge, ErrTooManyFiles, ErrLogLimit, ErrBudgetExceeded} {
+		if !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("errors.Is(%v, ErrLimitExceeded) = false", err)
+		}
+	}
+}
+
+func TestLimitedMemory(t *testing.T) {
+	const page = 65536
+	ctx, lim := newLimiter(context.Background(), resourceLimits{maxMemory: 4 * page})
+	defer lim.close()
+
+	mem := lim.allocate(page, 100*page)
+	buf := mem.Reallocate(page)
+	if len(buf) != page {
+		t.Fatalf("initial Reallocate returned %d bytes, want %d", len(buf), page)
+	}
+	buf[0] = 42
+	buf = mem.Reallocate(4 * page)
+	if len(buf) != 4*page || buf[0] != 42 {
+		t.Fatalf("Reallocate within limit: len=%d first=%d", len(buf), buf[0])
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("context done before limit was exceeded: %v", context.Cause(ctx))
+	}
+	if got := mem.Reallocate(5 * page); got != nil {
+		t.Fatalf("Reallocate past limit returned %d bytes, want nil", len(got))
+	}
+	if cause := context.Cause(ctx); !errors.Is(cause, ErrMemoryLimit) {
+		t.Errorf("context cause = %v, want ErrMemoryLimit", cause)
+	}
+}
+
+func TestFuelMeter(t *testing.T) {
+	ctx, lim := newLimiter(context.Background(), resourceLimits{fuelBudget: 3})
+	defer lim.close()
+
+	m, ok := ctx.Value(fuelKey{}).(*fuelMeter)
+	if !ok {
+		t.Fatal("run context carries no fuel meter")
+	}
+	for range 3 {
+		fuelListener.Before(ctx, nil, nil, nil, nil)
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("budget tripped within budget: %v", context.Cause(ctx))
+	}
+	fuelListener.Before(ctx, nil, nil, nil, nil)
+	if cause := context.Cause(ctx); !errors.Is(cause, ErrBudgetExceeded) {
+		t.Errorf("context cause = %v, want ErrBudgetExceeded", cause)
+	}
+	if got := lim.fuelUsed(); got != 4 || m.used != 4 {
+		t.Errorf("fuelUsed = %d, want 4", got)
+	}
+
+	_, unmetered := newLimiter(context.Background(), resourceLimits{})
+	defer unmetered.close()
+	if got := unmetered.fuelUsed(); got != 0 {
+		t.Errorf("unmetered fuelUsed = %d, want 0", got)
+	}
+}
+
+func TestCappedLog(t *testing.T) {
+	ctx, lim := newLimiter(context.Background(), resourceLimits{maxLogSize: 10})
+	defer lim.close()
+
+	var buf bytes.Buffer
+	w := lim.wrapLog(&buf)
+	_, _ = w.Write([]byte("12345"))
+	if ctx.Err() != nil {
+		t.Fatal("log limit tripped early")
+	}
+	_, _ = w.Write([]byte("67890abcdef"))
+	if got := buf.String(); got != "1234567890" {
+		t.Errorf("captured %q, want %q", got, "1234567890")
+	}
+	if cause := context.Cause(ctx); !errors.Is(cause, ErrLogLimit) {
+		t.Errorf("context cause = %v, want ErrLogLimit", cause)
+	}
+}
+
+func TestOutputFileLimit(t *testing.T) {
+	ctx, lim := newLimiter(context.Background(), resourceLimits{maxOutputFiles: 2})
+	defer lim.close()
+
+	fsys := lim.wrapOutput(newMemFS())
+	create := func(name string) experimentalsys.Errno {
+		f, errno := fsys.OpenFile(name, experimentalsys.O_CREAT|experimentalsys.O_WRONLY, 0o644)
+		if f != nil {
+			_ = f.Close()
+		}
+		return errno
+	}
+	for _, name := range []string{"a.aux", "a.pdf", "a.pdf"} {
+		if errno := create(name); errno != 0 {
+			t.Fatalf("create %s: %v", name, errno)
+		}
+	}
+	if errno := create("a.toc"); errno != experimentalsys.EPERM {
+		t.Errorf("third file: errno = %v, want EPERM", errno)
+	}
+	if cause := context.Cause(ctx); !errors.Is(cause, ErrTooManyFiles) {
+		t.Errorf("context cause = %v, want ErrTooManyFiles", cause)
+	}
+}
+
+func TestTimeLimit(t *testing.T) {
+	ctx, lim := newLimiter(context.Background(), resourceLimits{timeLimit: time.Millisecond})
+	defer lim.close()
+
+	<-ctx.Done()
+	if cause := interruptCause(ctx, ctx.Err()); !errors.Is(cause, ErrTimeLimit) {
+		t.Errorf("interruptCause = %v, want ErrTimeLimit", cause)
+	}
+}
+
+func TestCheckOutputPDFSize(t *testing.T) {
+	ws, err := newWorkspace(true, "")
+	if err != nil {
+		t.Fatalf("newWorkspace: %v", err)
+	}
+	defer ws.close()
+	if err := ws.writeFile("output", "input.pdf", make([]byte, 2048)); err != nil {
+		t.Fatalf("writeFile: %v", err)
+	}
+
+	_, lim := newLimiter(context.Background(), resourceLimits{maxPDFSize: 1024})
+	defer lim.close()
+	if err := lim.checkOutput(ws); !errors.Is(err, ErrPDFTooLarge) {
+		t.Errorf("checkOutput = %v, want ErrPDFTooLarge", err)
+	}
+
+	_, lim = newLimiter(context.Background(), resourceLimits{maxPDFSize: 4096})
+	defer lim.close()
+	if err := lim.checkOutput(ws); err != nil {
+		t.Errorf("checkOutput within limit = %v", err)
+	}
+}
@@ -0,0 +1,579 @@
+package tecgonic
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	experimentalsys "github.com/tetratelabs/wazero/experimental/sys"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// memFS is a writable, in-memory filesystem mounted into the WASM guest in
+// place of a host directory. It implements just enough of wazero's
+// experimental sys.FS for the engine to create, read, write, rename and
+// delete files, and lets the host side read the results back without
+// touching disk.
+type memFS struct {
+	experimentalsys.UnimplementedFS
+
+	mu      sync.Mutex
+	root    *memNode
+	nextIno sys.Inode
+}
+
+// memNode is a file or directory in a memFS.
+type memNode struct {
+	ino      sys.Inode
+	mode     fs.FileMode
+	data     []byte
+	children map[string]*memNode // nil for regular files
+	atim     int64
+	mtim     int64
+}
+
+func newMemFS() *memFS {
+	m := &memFS{}
+	m.root = m.newNode(fs.ModeDir | 0o755)
+	return m
+}
+
+func (m *memFS) newNode(mode fs.FileMode) *memNode {
+	m.nextIno++
+	now := time.Now().UnixNano()
+	n := &memNode{ino: m.nextIno, mode: mode, atim: now, mtim: now}
+	if mode.IsDir() {
+		n.children = map[string]*memNode{}
+	}
+	return n
+}
+
+func (n *memNode) isDir() bool {
+	return n.mode.IsDir()
+}
+
+func (n *memNode) stat() sys.Stat_t {
+	st := sys.Stat_t{
+		Ino:   n.ino,
+		Mode:  n.mode,
+		Nlink: 1,
+		Size:  int64(len(n.data)),
+		Atim:  n.atim,
+		Mtim:  n.mtim,
+		Ctim:  n.mtim,
+	}
+	if n.isDir() {
+		st.Nlink = 2
+		st.Size = 0
+	}
+	return st
+}
+
+// splitPath cleans a guest path and returns its components. Cleaning is done
+// relative to the mount root, so ".." can never escape it.
+func splitPath(p string) []string {
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return nil
+	}
+	return strings.Split(p[1:], "/")
+}
+
+// lookup resolves p to a node. Callers must hold m.mu.
+func (m *memFS) lookup(p string) (*memNode, experimentalsys.Errno) {
+	n := m.root
+	for _, part := range splitPath(p) {
+		if !n.isDir() {
+			return nil, experimentalsys.ENOTDIR
+		}
+		child, ok := n.children[part]
+		if !ok {
+			return nil, experimentalsys.ENOENT
+		}
+		n = child
+	}
+	return n, 0
+}
+
+// lookupParent resolves the directory containing p and returns it with p's
+// base name. Callers must hold m.mu.
+func (m *memFS) lookupParent(p string) (*memNode, string, experimentalsys.Errno) {
+	parts := splitPath(p)
+	if len(parts) == 0 {
+		return nil, "", experimentalsys.EINVAL
+	}
+	dir, errno := m.lookup(strings.Join(parts[:len(parts)-1], "/"))
+	if errno != 0 {
+		return nil, "", errno
+	}
+	if !dir.isDir() {
+		return nil, "", experimentalsys.ENOTDIR
+	}
+	return dir, parts[len(parts)-1], 0
+}
+
+// OpenFile implements experimentalsys.FS.
+func (m *memFS) OpenFile(p string, flag experimentalsys.Oflag, perm fs.FileMode) (experimentalsys.File, experimentalsys.Errno) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, errno := m.lookup(p)
+	switch {
+	case errno == experimentalsys.ENOENT && flag&experimentalsys.O_CREAT != 0:
+		dir, name, errno := m.lookupParent(p)
+		if errno != 0 {
+			return nil, errno
+		}
+		n = m.newNode(perm.Perm())
+		dir.children[name] = n
+		dir.mtim = n.mtim
+	case errno != 0:
+		return nil, errno
+	case flag&experimentalsys.O_CREAT != 0 && flag&experimentalsys.O_EXCL != 0:
+		return nil, experimentalsys.EEXIST
+	}
+
+	writable := flag&(experimentalsys.O_RDWR|experimentalsys.O_WRONLY) != 0
+	if n.isDir() && writable {
+		return nil, experimentalsys.EISDIR
+	}
+	if !n.isDir() && flag&experimentalsys.O_DIRECTORY != 0 {
+		return nil, experimentalsys.ENOTDIR
+	}
+	if !n.isDir() && writable && flag&experimentalsys.O_TRUNC != 0 {
+		n.data = n.data[:0]
+		n.mtim = time.Now().UnixNano()
+	}
+
+	f := &memFile{
+		fs:       m,
+		node:     n,
+		readable: flag&experimentalsys.O_WRONLY == 0,
+		writable: writable,
+		append:   flag&experimentalsys.O_APPEND != 0,
+	}
+	return f, 0
+}
+
+// Lstat implements experimentalsys.FS. memFS has no symbolic links.
+func (m *memFS) Lstat(p string) (sys.Stat_t, experimentalsys.Errno) {
+	return m.Stat(p)
+}
+
+// Stat implements experimentalsys.FS.
+func (m *memFS) Stat(p string) (sys.Stat_t, experimentalsys.Errno) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, errno := m.lookup(p)
+	if errno != 0 {
+		return sys.Stat_t{}, errno
+	}
+	return n.stat(), 0
+}
+
+// Mkdir implements experimentalsys.FS.
+func (m *memFS) Mkdir(p string, perm fs.FileMode) experimentalsys.Errno {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dir, name, errno := m.lookupParent(p)
+	if errno != 0 {
+		return errno
+	}
+	if _, ok := dir.children[name]; ok {
+		return experimentalsys.EEXIST
+	}
+	dir.children[name] = m.newNode(fs.ModeDir | perm.Perm())
+	return 0
+}
+
+// Chmod implements experimentalsys.FS.
+func (m *memFS) Chmod(p string, perm fs.FileMode) experimentalsys.Errno {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, errno := m.lookup(p)
+	if errno != 0 {
+		return errno
+	}
+	n.mode = n.mode.Type() | perm.Perm()
+	return 0
+}
+
+// Rename implements experimentalsys.FS.
+func (m *memFS) Rename(from, to string) experimentalsys.Errno {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fromDir, fromName, errno := m.lookupParent(from)
+	if errno != 0 {
+		return errno
+	}
+	n, ok := fromDir.children[fromName]
+	if !ok {
+		return experimentalsys.ENOENT
+	}
+	toDir, toName, errno := m.lookupParent(to)
+	if errno != 0 {
+		return errno
+	}
+	if existing, ok := toDir.children[toName]; ok {
+		switch {
+		case existing == n:
+			return 0
+		case existing.isDir() && !n.isDir():
+			return experimentalsys.EISDIR
+		case !existing.isDir() && n.isDir():
+			return experimentalsys.ENOTDIR
+		case existing.isDir() && len(existing.children) > 0:
+			return experimentalsys.ENOTEMPTY
+		}
+	}
+	delete(fromDir.children, fromName)
+	toDir.children[toName] = n
+	return 0
+}
+
+// Rmdir implements experimentalsys.FS.
+func (m *memFS) Rmdir(p string) experimentalsys.Errno {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dir, name, errno := m.lookupParent(p)
+	if errno != 0 {
+		return errno
+	}
+	n, ok := dir.children[name]
+	switch {
+	case !ok:
+		return experimentalsys.ENOENT
+	case !n.isDir():
+		return experimentalsys.ENOTDIR
+	case len(n.children) > 0:
+		return experimentalsys.ENOTEMPTY
+	}
+	delete(dir.children, name)
+	return 0
+}
+
+// Unlink implements experimentalsys.FS.
+func (m *memFS) Unlink(p string) experimentalsys.Errno {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dir, name, errno := m.lookupParent(p)
+	if errno != 0 {
+		return errno
+	}
+	n, ok := dir.children[name]
+	switch {
+	case !ok:
+		return experimentalsys.ENOENT
+	case n.isDir():
+		return experimentalsys.EISDIR
+	}
+	delete(dir.children, name)
+	return 0
+}
+
+// Utimens implements experimentalsys.FS.
+func (m *memFS) Utimens(p string, atim, mtim int64) experimentalsys.Errno {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, errno := m.lookup(p)
+	if errno != 0 {
+		return errno
+	}
+	n.utimens(atim, mtim)
+	return 0
+}
+
+func (n *memNode) utimens(atim, mtim int64) {
+	if atim != experimentalsys.UTIME_OMIT {
+		n.atim = atim
+	}
+	if mtim != experimentalsys.UTIME_OMIT {
+		n.mtim = mtim
+	}
+}
+
+// WriteFile creates or replaces the file at p, creating parent directories
+// as needed. It is used by the host to stage inputs.
+func (m *memFS) WriteFile(p string, data []byte) error {
+	parts := splitPath(p)
+	for i := 1; i < len(parts); i++ {
+		if errno := m.Mkdir(strings.Join(parts[:i], "/"), 0o755); errno != 0 && errno != experimentalsys.EEXIST {
+			return &fs.PathError{Op: "mkdir", Path: p, Err: errno}
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dir, name, errno := m.lookupParent(p)
+	if errno != 0 {
+		return &fs.PathError{Op: "write", Path: p, Err: errno}
+	}
+	n := m.newNode(0o644)
+	n.data = append([]byte(nil), data...)
+	dir.children[name] = n
+	return nil
+}
+
+// Files returns the names of the regular files in the root directory.
+func (m *memFS) Files() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var names []string
+	for name, n := range m.root.children {
+		if !n.isDir() {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ReadFile returns a copy of the contents of the regular file at p.
+func (m *memFS) ReadFile(p string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, errno := m.lookup(p)
+	if errno != 0 {
+		return nil, &fs.PathError{Op: "read", Path: p, Err: errno}
+	}
+	if n.isDir() {
+		return nil, &fs.PathError{Op: "read", Path: p, Err: experimentalsys.EISDIR}
+	}
+	return append([]byte(nil), n.data...), nil
+}
+
+// memFile is an open handle on a memNode.
+type memFile struct {
+	fs       *memFS
+	node     *memNode
+	readable bool
+	writable bool
+	append   bool
+	offset   int64
+	dirents  []experimentalsys.Dirent // remaining entries for Readdir, nil until first call
+	closed   bool
+}
+
+// Dev implements experimentalsys.File.
+func (f *memFile) Dev() (uint64, experimentalsys.Errno) {
+	return 0, 0
+}
+
+// Ino implements experimentalsys.File.
+func (f *memFile) Ino() (sys.Inode, experimentalsys.Errno) {
+	return f.node.ino, 0
+}
+
+// IsDir implements experimentalsys.File.
+func (f *memFile) IsDir() (bool, experimentalsys.Errno) {
+	return f.node.isDir(), 0
+}
+
+// IsAppend implements experimentalsys.File.
+func (f *memFile) IsAppend() bool {
+	return f.append
+}
+
+// SetAppend implements experimentalsys.File.
+func (f *memFile) SetAppend(enable bool) experimentalsys.Errno {
+	f.append = enable
+	return 0
+}
+
+// Stat implements experimentalsys.File.
+func (f *memFile) Stat() (sys.Stat_t, experimentalsys.Errno) {
+	if f.closed {
+		return sys.Stat_t{}, experimentalsys.EBADF
+	}
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return f.node.stat(), 0
+}
+
+// Read implements experimentalsys.File.
+func (f *memFile) Read(buf []byte) (int, experimentalsys.Errno) {
+	n, errno := f.Pread(buf, f.offset)
+	f.offset += int64(n)
+	return n, errno
+}
+
+// Pread implements experimentalsys.File.
+func (f *memFile) Pread(buf []byte, off int64) (int, experimentalsys.Errno) {
+	if f.closed || !f.readable {
+		return 0, experimentalsys.EBADF
+	}
+	if f.node.isDir() {
+		return 0, experimentalsys.EISDIR
+	}
+	if off < 0 {
+		return 0, experimentalsys.EINVAL
+	}
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if off >= int64(len(f.node.data)) {
+		return 0, 0
+	}
+	return copy(buf, f.node.data[off:]), 0
+}
+
+// fileOffset is an offset in a memFile. experimentalsys.File.Seek returns an
+// Errno rather than an error; declaring its offset with this alias keeps go
+// vet from taking memFile.Seek for a malformed io.Seeker.
+type fileOffset = int64
+
+// Seek implements experimentalsys.File.
+func (f *memFile) Seek(offset fileOffset, whence int) (int64, experimentalsys.Errno) {
+	if f.closed {
+		return 0, experimentalsys.EBADF
+	}
+	if f.node.isDir() {
+		if offset != 0 || whence != io.SeekStart {
+			return 0, experimentalsys.EINVAL
+		}
+		f.dirents = nil
+		return 0, 0
+	}
+	f.fs.mu.Lock()
+	size := int64(len(f.node.data))
+	f.fs.mu.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += size
+	default:
+		return 0, experimentalsys.EINVAL
+	}
+	if offset < 0 {
+		return 0, experimentalsys.EINVAL
+	}
+	f.offset = offset
+	return offset, 0
+}
+
+// Readdir implements experimentalsys.File.
+func (f *memFile) Readdir(n int) ([]experimentalsys.Dirent, experimentalsys.Errno) {
+	if f.closed {
+		return nil, experimentalsys.EBADF
+	}
+	if !f.node.isDir() {
+		return nil, experimentalsys.ENOTDIR
+	}
+	if f.dirents == nil {
+		f.fs.mu.Lock()
+		names := make([]string, 0, len(f.node.children))
+		for name := range f.node.children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		f.dirents = make([]experimentalsys.Dirent, 0, len(names))
+		for _, name := range names {
+			child := f.node.children[name]
+			f.dirents = append(f.dirents, experimentalsys.Dirent{Ino: child.ino, Name: name, Type: child.mode.Type()})
+		}
+		f.fs.mu.Unlock()
+	}
+	if n <= 0 || n > len(f.dirents) {
+		n = len(f.dirents)
+	}
+	out := f.dirents[:n]
+	f.dirents = f.dirents[n:]
+	return out, 0
+}
+
+// Write implements experimentalsys.File.
+func (f *memFile) Write(buf []byte) (int, experimentalsys.Errno) {
+	if f.append {
+		f.fs.mu.Lock()
+		f.offset = int64(len(f.node.data))
+		f.fs.mu.Unlock()
+	}
+	n, errno := f.Pwrite(buf, f.offset)
+	f.offset += int64(n)
+	return n, errno
+}
+
+// Pwrite implements experimentalsys.File.
+func (f *memFile) Pwrite(buf []byte, off int64) (int, experimentalsys.Errno) {
+	if f.closed || !f.writable {
+		return 0, experimentalsys.EBADF
+	}
+	if f.node.isDir() {
+		return 0, experimentalsys.EISDIR
+	}
+	if off < 0 {
+		return 0, experimentalsys.EINVAL
+	}
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	end := off + int64(len(buf))
+	if end > int64(len(f.node.data)) {
+		f.node.grow(end)
+	}
+	copy(f.node.data[off:], buf)
+	f.node.mtim = time.Now().UnixNano()
+	return len(buf), 0
+}
+
+// grow extends the file to size bytes, zero-filling the new region.
+func (n *memNode) grow(size int64) {
+	if size <= int64(cap(n.data)) {
+		old := len(n.data)
+		n.data = n.data[:size]
+		clear(n.data[old:])
+		return
+	}
+	data := make([]byte, size, max(size, 2*int64(cap(n.data))))
+	copy(data, n.data)
+	n.data = data
+}
+
+// Truncate implements experimentalsys.File.
+func (f *memFile) Truncate(size int64) experimentalsys.Errno {
+	if f.closed || !f.writable {
+		return experimentalsys.EBADF
+	}
+	if size < 0 {
+		return experimentalsys.EINVAL
+	}
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if size > int64(len(f.node.data)) {
+		f.node.grow(size)
+	} else {
+		f.node.data = f.node.data[:size]
+	}
+	f.node.mtim = time.Now().UnixNano()
+	return 0
+}
+
+// Sync implements experimentalsys.File.
+func (f *memFile) Sync() experimentalsys.Errno {
+	return 0
+}
+
+// Datasync implements experimentalsys.File.
+func (f *memFile) Datasync() experimentalsys.Errno {
+	return 0
+}
+
+// Utimens implements experimentalsys.File.
+func (f *memFile) Utimens(atim, mtim int64) experimentalsys.Errno {
+	if f.closed {
+		return experimentalsys.EBADF
+	}
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	f.node.utimens(atim, mtim)
+	return 0
+}
+
+// Close implements experimentalsys.File.
+func (f *memFile) Close() experimentalsys.Errno {
+	f.closed = true
+	return 0
+}
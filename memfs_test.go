@@ -0,0 +1,126 @@
+package tecgonic
+
+import (
+	"io"
+	"testing"
+
+	experimentalsys "github.com/tetratelabs/wazero/experimental/sys"
+)
+
+func TestMemFSReadWrite(t *testing.T) {
+	m := newMemFS()
+
+	if errno := m.Mkdir("sub", 0o755); errno != 0 {
+		t.Fatalf("Mkdir: %v", errno)
+	}
+	f, errno := m.OpenFile("sub/a.txt", experimentalsys.O_CREAT|experimentalsys.O_RDWR, 0o644)
+	if errno != 0 {
+		t.Fatalf("OpenFile: %v", errno)
+	}
+	if _, errno := f.Write([]byte("hello world")); errno != 0 {
+		t.Fatalf("Write: %v", errno)
+	}
+	if _, errno := f.Seek(6, io.SeekStart); errno != 0 {
+		t.Fatalf("Seek: %v", errno)
+	}
+	if _, errno := f.Write([]byte("there")); errno != 0 {
+		t.Fatalf("Write: %v", errno)
+	}
+	if _, errno := f.Seek(0, io.SeekStart); errno != 0 {
+		t.Fatalf("Seek: %v", errno)
+	}
+	buf := make([]byte, 32)
+	n, errno := f.Read(buf)
+	if errno != 0 {
+		t.Fatalf("Read: %v", errno)
+	}
+	if got := string(buf[:n]); got != "hello there" {
+		t.Errorf("Read = %q, want %q", got, "hello there")
+	}
+	_ = f.Close()
+
+	st, errno := m.Stat("sub/a.txt")
+	if errno != 0 {
+		t.Fatalf("Stat: %v", errno)
+	}
+	if st.Size != 11 {
+		t.Errorf("Size = %d, want 11", st.Size)
+	}
+
+	data, err := m.ReadFile("sub/a.txt")
+	if err != nil || string(data) != "hello there" {
+		t.Errorf("ReadFile = %q, %v", data, err)
+	}
+}
+
+func TestMemFSErrors(t *testing.T) {
+	m := newMemFS()
+	if err := m.WriteFile("dir/file.tex", []byte("x")); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  experimentalsys.Errno
+		want experimentalsys.Errno
+	}{
+		{"open missing", openErrno(m, "missing", experimentalsys.O_RDONLY), experimentalsys.ENOENT},
+		{"open excl", openErrno(m, "dir/file.tex", experimentalsys.O_CREAT|experimentalsys.O_EXCL|experimentalsys.O_WRONLY), experimentalsys.EEXIST},
+		{"open dir writable", openErrno(m, "dir", experimentalsys.O_WRONLY), experimentalsys.EISDIR},
+		{"open file as dir", openErrno(m, "dir/file.tex", experimentalsys.O_DIRECTORY), experimentalsys.ENOTDIR},
+		{"create in missing dir", openErrno(m, "nope/x", experimentalsys.O_CREAT|experimentalsys.O_WRONLY), experimentalsys.ENOENT},
+		{"rmdir non-empty", m.Rmdir("dir"), experimentalsys.ENOTEMPTY},
+		{"unlink dir", m.Unlink("dir"), experimentalsys.EISDIR},
+		{"mkdir existing", m.Mkdir("dir", 0o755), experimentalsys.EEXIST},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func openErrno(m *memFS, path string, flag experimentalsys.Oflag) experimentalsys.Errno {
+	f, errno := m.OpenFile(path, flag, 0o644)
+	if f != nil {
+		_ = f.Close()
+	}
+	return errno
+}
+
+func TestMemFSRenameAndReaddir(t *testing.T) {
+	m := newMemFS()
+	for _, name := range []string{"b.aux", "a.log", "c.pdf.tmp"} {
+		if err := m.WriteFile(name, []byte(name)); err != nil {
+			t.Fatalf("WriteFile(%s): %v", name, err)
+		}
+	}
+	if errno := m.Rename("c.pdf.tmp", "c.pdf"); errno != 0 {
+		t.Fatalf("Rename: %v", errno)
+	}
+	if errno := m.Unlink("b.aux"); errno != 0 {
+		t.Fatalf("Unlink: %v", errno)
+	}
+
+	dir, errno := m.OpenFile(".", experimentalsys.O_RDONLY|experimentalsys.O_DIRECTORY, 0)
+	if errno != 0 {
+		t.Fatalf("OpenFile(.): %v", errno)
+	}
+	defer func() { _ = dir.Close() }()
+
+	first, errno := dir.Readdir(1)
+	if errno != 0 || len(first) != 1 {
+		t.Fatalf("Readdir(1) = %v, %v", first, errno)
+	}
+	rest, errno := dir.Readdir(-1)
+	if errno != 0 {
+		t.Fatalf("Readdir(-1): %v", errno)
+	}
+	var names []string
+	for _, d := range append(first, rest...) {
+		names = append(names, d.Name)
+	}
+	if len(names) != 2 || names[0] != "a.log" || names[1] != "c.pdf" {
+		t.Errorf("Readdir names = %v, want [a.log c.pdf]", names)
+	}
+}
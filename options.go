@@ -1,12 +1,21 @@
 package tecgonic
 
-import "io"
+import (
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
 
 // compilerConfig holds configuration set once on New().
 type compilerConfig struct {
 	defaultBundleDir    string
+	defaultBundleFS     fs.FS
 	defaultFontsDir     string
 	compilationCacheDir string
+	inMemory            bool
+	poolSize            int
+	overlays            []fs.FS
 }
 
 // CompilerOption configures a Compiler at creation time.
@@ -16,6 +25,34 @@ type CompilerOption func(*compilerConfig)
 func WithDefaultBundleDir(dir string) CompilerOption {
 	return func(c *compilerConfig) {
 		c.defaultBundleDir = dir
+		c.defaultBundleFS = nil
+	}
+}
+
+// WithDefaultBundleFS sets the default bundle for all compilations to the
+// given filesystem. See WithBundleFS.
+func WithDefaultBundleFS(fsys fs.FS) CompilerOption {
+	return func(c *compilerConfig) {
+		c.defaultBundleFS = fsys
+		c.defaultBundleDir = ""
+	}
+}
+
+// WithDefaultOverlay layers fsys in front of the bundle for all
+// compilations. See WithOverlay.
+func WithDefaultOverlay(fsys ...fs.FS) CompilerOption {
+	return func(c *compilerConfig) {
+		c.overlays = append(c.overlays, fsys...)
+	}
+}
+
+// WithDefaultIncludeDirs layers dirs in front of the bundle for all
+// compilations. See WithIncludeDirs.
+func WithDefaultIncludeDirs(dirs ...string) CompilerOption {
+	return func(c *compilerConfig) {
+		for _, dir := range dirs {
+			c.overlays = append(c.overlays, os.DirFS(dir))
+		}
 	}
 }
 
@@ -34,6 +71,35 @@ func WithCompilationCache(dir string) CompilerOption {
 	}
 }
 
+// WithDefaultInMemoryFS backs the input, output and cache directories of all
+// compilations with in-memory filesystems. See WithInMemoryFS.
+func WithDefaultInMemoryFS() CompilerOption {
+	return func(c *compilerConfig) {
+		c.inMemory = true
+	}
+}
+
+// WithInstancePool keeps n engine instances instantiated ahead of time, so
+// compilations skip module instantiation and WASI setup. Taken instances are
+// replaced in the background; use Compiler.PoolStats to see how often the
+// pool was empty and why it failed to refill, if it did. Loading latex.fmt is
+// part of the engine run itself and is not saved. Each idle instance holds
+// its own linear memory.
+//
+// Pooled instances are mounted with the Compiler's defaults, so compilations
+// bypass the pool when they use CompileFS or CompileFSDetailed, set their own
+// bundle with WithBundleDir, WithBundleFS or WithBundle, set a different
+// fonts directory with WithFontsDir, use WithInMemoryFS on a Compiler without
+// WithDefaultInMemoryFS, add overlays with WithOverlay or WithIncludeDirs, or
+// set WithMaxMemory, WithMaxOutputFiles or WithFuelBudget. The pool requires
+// a default bundle, set with WithDefaultBundleDir, WithDefaultBundleFS or
+// WithDefaultBundle.
+func WithInstancePool(n int) CompilerOption {
+	return func(c *compilerConfig) {
+		c.poolSize = n
+	}
+}
+
 // generateFormatConfig holds per-call configuration for GenerateFormat().
 type generateFormatConfig struct {
 	stderr io.Writer
@@ -53,9 +119,18 @@ func WithGenerateFormatStderr(w io.Writer) GenerateFormatOption {
 // compileConfig holds per-call configuration for Compile().
 type compileConfig struct {
 	bundleDir string
+	bundleFS  fs.FS
+	bundleSet bool // bundle overridden for this compilation
+	overlays  []fs.FS
 	fontsDir  string
 	stderr    io.Writer
 	output    io.Writer
+	inMemory  bool
+
+	failOnWarnings bool
+	failCategories []Category
+
+	limits resourceLimits
 }
 
 // CompileOption configures a single Compile() call.
@@ -65,6 +140,43 @@ type CompileOption func(*compileConfig)
 func WithBundleDir(dir string) CompileOption {
 	return func(c *compileConfig) {
 		c.bundleDir = dir
+		c.bundleFS = nil
+		c.bundleSet = true
+	}
+}
+
+// WithBundleFS serves the bundle for this compilation from fsys instead of a
+// directory, e.g. an embed.FS, a zip.Reader or a custom virtual filesystem.
+// The bundle files, including latex.fmt (see GenerateFormatFS), must be at
+// the root of fsys. fsys is mounted read-only.
+func WithBundleFS(fsys fs.FS) CompileOption {
+	return func(c *compileConfig) {
+		c.bundleFS = fsys
+		c.bundleDir = ""
+		c.bundleSet = true
+	}
+}
+
+// WithOverlay layers fsys, read-only, in front of the bundle for this
+// compilation, e.g. to add in-house classes and styles or a local texmf
+// tree. Files are found by base name at any depth of fsys, as the engine
+// looks them up in the bundle. Overlays take precedence in the order given,
+// those of this compilation before the Compiler's (see WithDefaultOverlay),
+// and all before the bundle. They are indexed once per compilation; those of
+// the Compiler once, by New.
+func WithOverlay(fsys ...fs.FS) CompileOption {
+	return func(c *compileConfig) {
+		c.overlays = append(c.overlays, fsys...)
+	}
+}
+
+// WithIncludeDirs layers the directories dirs in front of the bundle for this
+// compilation. See WithOverlay.
+func WithIncludeDirs(dirs ...string) CompileOption {
+	return func(c *compileConfig) {
+		for _, dir := range dirs {
+			c.overlays = append(c.overlays, os.DirFS(dir))
+		}
 	}
 }
 
@@ -89,3 +201,81 @@ func WithOutput(w io.Writer) CompileOption {
 		c.output = w
 	}
 }
+
+// WithInMemoryFS backs the input, output and cache directories (and the fonts
+// directory, unless one is set) with in-memory filesystems for this
+// compilation, so nothing is written to disk. Only the bundle is read from
+// the host filesystem.
+func WithInMemoryFS() CompileOption {
+	return func(c *compileConfig) {
+		c.inMemory = true
+	}
+}
+
+// WithFailOnWarnings makes a compilation that succeeds with warnings return a
+// *CompileError whose Cause wraps ErrWarnings, for example to gate CI on
+// "no undefined references". With no categories every warning fails the
+// compilation; otherwise only warnings in one of the given categories do.
+func WithFailOnWarnings(categories ...Category) CompileOption {
+	return func(c *compileConfig) {
+		c.failOnWarnings = true
+		c.failCategories = categories
+	}
+}
+
+// WithMaxMemory limits the WASM linear memory of this compilation to the given
+// number of bytes. Exceeding it fails the compilation with ErrMemoryLimit.
+func WithMaxMemory(bytes uint64) CompileOption {
+	return func(c *compileConfig) {
+		c.limits.maxMemory = bytes
+	}
+}
+
+// WithTimeLimit limits the wall-clock time of this compilation. Exceeding it
+// fails the compilation with ErrTimeLimit, unlike a deadline on the context
+// passed to Compile, which fails with ErrTimeout.
+func WithTimeLimit(d time.Duration) CompileOption {
+	return func(c *compileConfig) {
+		c.limits.timeLimit = d
+	}
+}
+
+// WithMaxPDFSize limits the size of the produced PDF to the given number of
+// bytes. Exceeding it fails the compilation with ErrPDFTooLarge.
+func WithMaxPDFSize(bytes int64) CompileOption {
+	return func(c *compileConfig) {
+		c.limits.maxPDFSize = bytes
+	}
+}
+
+// WithMaxOutputFiles limits the number of files the engine may create in its
+// output directory. Exceeding it fails the compilation with ErrTooManyFiles.
+func WithMaxOutputFiles(n int) CompileOption {
+	return func(c *compileConfig) {
+		c.limits.maxOutputFiles = n
+	}
+}
+
+// WithMaxLogSize limits how many bytes of tectonic's diagnostic output are
+// captured. Exceeding it stops the compilation with ErrLogLimit; the output
+// captured up to the limit is kept in the error.
+func WithMaxLogSize(bytes int) CompileOption {
+	return func(c *compileConfig) {
+		c.limits.maxLogSize = bytes
+	}
+}
+
+// WithFuelBudget limits this compilation to the given amount of fuel, where one
+// unit is one WebAssembly function call made by the engine. Unlike
+// WithTimeLimit the count does not depend on machine load, so a document either
+// fits in the budget everywhere or fails everywhere with ErrBudgetExceeded.
+// CompileResult.Fuel reports what a successful run consumed, which helps in
+// choosing a budget.
+//
+// Metered runs use a separately compiled, instrumented copy of the engine,
+// which is compiled on first use and runs noticeably slower.
+func WithFuelBudget(units uint64) CompileOption {
+	return func(c *compileConfig) {
+		c.limits.fuelBudget = units
+	}
+}
@@ -0,0 +1,150 @@
+package tecgonic
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"sync"
+)
+
+// overlayIndex locates the files of overlay layers. Like kpathsea searching
+// a texmf tree, a layer's files are found by base name at any depth; the
+// first layer holding a name wins, and within a layer the first path in
+// lexical order.
+type overlayIndex map[string]overlayFile
+
+// overlayFile locates a file in an overlay layer.
+type overlayFile struct {
+	fsys fs.FS
+	path string
+}
+
+// newOverlayIndex indexes layers, in order of precedence.
+func newOverlayIndex(layers []fs.FS) (overlayIndex, error) {
+	idx := make(overlayIndex)
+	for _, layer := range layers {
+		err := fs.WalkDir(layer, ".", func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			if _, ok := idx[d.Name()]; !ok {
+				idx[d.Name()] = overlayFile{fsys: layer, path: p}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("tecgonic: indexing overlay: %w", err)
+		}
+	}
+	return idx, nil
+}
+
+// overlays returns the overlay index of a compilation: its own overlays,
+// indexed now, in front of the Compiler's, indexed by New.
+func (c *Compiler) overlays(cfg compileConfig) (overlayIndex, error) {
+	if len(cfg.overlays) == 0 {
+		return c.overlay, nil
+	}
+	idx, err := newOverlayIndex(cfg.overlays)
+	if err != nil {
+		return nil, err
+	}
+	for name, f := range c.overlay {
+		if _, ok := idx[name]; !ok {
+			idx[name] = f
+		}
+	}
+	return idx, nil
+}
+
+// bundleMount returns the filesystem to mount at /bundle for m, or nil to
+// mount m.bundleDir directly, as is done without overlays.
+func (m mounts) bundleMount() fs.FS {
+	if len(m.overlay) == 0 {
+		return m.bundleFS
+	}
+	bundle := m.bundleFS
+	if bundle == nil {
+		bundle = os.DirFS(m.bundleDir)
+	}
+	return &overlayFS{index: m.overlay, bundle: bundle}
+}
+
+// overlayFS serves the files of an overlayIndex in front of a bundle.
+type overlayFS struct {
+	index  overlayIndex
+	bundle fs.FS
+}
+
+// Open implements fs.FS.
+func (o *overlayFS) Open(name string) (fs.File, error) {
+	if name == "." {
+		return &overlayRoot{o: o}, nil
+	}
+	if f, ok := o.index[name]; ok {
+		return f.fsys.Open(f.path)
+	}
+	return o.bundle.Open(name)
+}
+
+// Stat implements fs.StatFS.
+func (o *overlayFS) Stat(name string) (fs.FileInfo, error) {
+	if name == "." {
+		return entryInfo{name: ".", dir: true}, nil
+	}
+	if f, ok := o.index[name]; ok {
+		return fs.Stat(f.fsys, f.path)
+	}
+	return fs.Stat(o.bundle, name)
+}
+
+// overlayRoot is the open root directory of an overlayFS. The names of the
+// bundle are only listed if it is read.
+type overlayRoot struct {
+	o    *overlayFS
+	once sync.Once
+	dir  *indexDir
+	err  error
+}
+
+func (d *overlayRoot) Stat() (fs.FileInfo, error) {
+	return entryInfo{name: ".", dir: true}, nil
+}
+
+func (d *overlayRoot) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: ".", Err: errors.New("is a directory")}
+}
+
+func (d *overlayRoot) Close() error { return nil }
+
+func (d *overlayRoot) ReadDir(n int) ([]fs.DirEntry, error) {
+	d.once.Do(func() {
+		entries, err := fs.ReadDir(d.o.bundle, ".")
+		if err != nil {
+			d.err = err
+			return
+		}
+		names := make(map[string]bool, len(entries)+len(d.o.index))
+		for _, e := range entries {
+			names[e.Name()] = true
+		}
+		for name := range d.o.index {
+			names[name] = true
+		}
+		list := make([]string, 0, len(names))
+		for name := range names {
+			list = append(list, name)
+		}
+		slices.Sort(list)
+		d.dir = &indexDir{fsys: d.o, names: list}
+	})
+	if d.err != nil {
+		return nil, d.err
+	}
+	return d.dir.ReadDir(n)
+}
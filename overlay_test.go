@@ -0,0 +1,127 @@
+package tecgonic
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+// overlayBundle layers layers in front of bundle as a compilation mounts them.
+func overlayBundle(t *testing.T, layers []fs.FS, bundle fs.FS) fs.StatFS {
+	t.Helper()
+	idx, err := newOverlayIndex(layers)
+	if err != nil {
+		t.Fatalf("newOverlayIndex: %v", err)
+	}
+	return mounts{bundleFS: bundle, overlay: idx}.bundleMount().(fs.StatFS)
+}
+
+func TestOverlayFS(t *testing.T) {
+	team := fstest.MapFS{
+		"tex/latex/corp/corp.cls": {Data: []byte("team corp")},
+		"tex/latex/zz/corp.cls":   {Data: []byte("shadowed within the layer")},
+	}
+	company := fstest.MapFS{
+		"corp.cls":  {Data: []byte("company corp")},
+		"extra.sty": {Data: []byte("company extra")},
+	}
+	bundle := fstest.MapFS{
+		"article.cls": {Data: []byte("bundle article")},
+		"corp.cls":    {Data: []byte("bundle corp")},
+	}
+	o := overlayBundle(t, []fs.FS{team, company}, bundle)
+
+	for name, want := range map[string]string{
+		"corp.cls":    "team corp",
+		"extra.sty":   "company extra",
+		"article.cls": "bundle article",
+	} {
+		got, err := fs.ReadFile(o, name)
+		if err != nil || string(got) != want {
+			t.Errorf("ReadFile(%s) = %q, %v; want %q", name, got, err, want)
+		}
+		if info, err := o.Stat(name); err != nil || info.Size() != int64(len(want)) {
+			t.Errorf("Stat(%s) = %v, %v", name, info, err)
+		}
+	}
+	if _, err := o.Open("missing.sty"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open(missing.sty) = %v, want fs.ErrNotExist", err)
+	}
+
+	entries, err := fs.ReadDir(o, ".")
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if want := []string{"article.cls", "corp.cls", "extra.sty"}; !slices.Equal(names, want) {
+		t.Errorf("ReadDir = %v, want %v", names, want)
+	}
+}
+
+func TestOverlayMissingDir(t *testing.T) {
+	_, err := newOverlayIndex([]fs.FS{os.DirFS(filepath.Join(t.TempDir(), "missing"))})
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("newOverlayIndex = %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestOverlayPrecedence(t *testing.T) {
+	var cc compilerConfig
+	WithDefaultOverlay(fstest.MapFS{"corp.sty": {Data: []byte("compiler")}, "house.cls": {}})(&cc)
+	idx, err := newOverlayIndex(cc.overlays)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Compiler{config: cc, overlay: idx}
+
+	var cfg compileConfig
+	WithOverlay(fstest.MapFS{"corp.sty": {Data: []byte("first")}})(&cfg)
+	WithIncludeDirs(t.TempDir())(&cfg)
+	WithOverlay(fstest.MapFS{"corp.sty": {Data: []byte("last")}, "extra.sty": {}})(&cfg)
+
+	got, err := c.overlays(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := fs.ReadFile(got["corp.sty"].fsys, got["corp.sty"].path); string(data) != "first" {
+		t.Errorf("corp.sty from the %q overlay, want the first", data)
+	}
+	for _, name := range []string{"house.cls", "extra.sty"} {
+		if _, ok := got[name]; !ok {
+			t.Errorf("%s missing from the index", name)
+		}
+	}
+	if len(c.overlay) != 2 {
+		t.Errorf("Compiler index modified: %d files", len(c.overlay))
+	}
+
+	// Without overlays of its own, a compilation reuses the Compiler's index.
+	if got, _ := c.overlays(compileConfig{}); len(got) != 2 {
+		t.Errorf("index of a compilation without overlays = %v", got)
+	}
+}
+
+func TestBundleMountNative(t *testing.T) {
+	if m := (mounts{bundleDir: t.TempDir()}).bundleMount(); m != nil {
+		t.Errorf("bundleMount without overlays = %T, want the directory mounted natively", m)
+	}
+	bundle := fstest.MapFS{}
+	if m := (mounts{bundleFS: bundle}).bundleMount(); m == nil {
+		t.Error("bundleMount dropped the bundle filesystem")
+	}
+}
+
+func TestPreflightOverlay(t *testing.T) {
+	o := overlayBundle(t, []fs.FS{fstest.MapFS{"texmf/corp.sty": {Data: []byte("% corp\n")}}}, preflightBundle)
+	src := []byte("\\documentclass{article}\n\\usepackage{corp}\n")
+	if _, err := preflight(t.Context(), nil, o, "input.tex", src); err != nil {
+		t.Errorf("preflight: %v", err)
+	}
+}
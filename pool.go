@@ -0,0 +1,183 @@
+package tecgonic
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// poolRetryDelay is how long the pool waits before retrying after it
+	// failed to create an instance. The delay doubles with each consecutive
+	// failure, up to poolMaxRetryDelay.
+	poolRetryDelay    = time.Second
+	poolMaxRetryDelay = 30 * time.Second
+
+	// poolMaxFailures is how many consecutive failures make the pool stop
+	// refilling.
+	poolMaxFailures = 5
+)
+
+// PoolStats reports how compilations were served by the instance pool set up
+// with WithInstancePool.
+type PoolStats struct {
+	Hits     uint64 // compilations that took a pre-warmed instance
+	Misses   uint64 // compilations that found the pool empty and instantiated their own
+	Bypassed uint64 // compilations whose options ruled out pooled instances
+	Idle     int    // pre-warmed instances currently waiting in the pool
+
+	// Err is the last error creating an instance, nil once one is created
+	// again. Stopped is set when the pool gave up after poolMaxFailures
+	// consecutive failures; compilations then instantiate their own.
+	Err     error
+	Stopped bool
+}
+
+// instancePool keeps pre-instantiated engines, mounted with the Compiler's
+// default directories, ready for compilations. A background goroutine
+// replaces every instance that is taken.
+type instancePool struct {
+	ready  chan *instance
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	hits, misses, bypassed atomic.Uint64
+
+	mu      sync.Mutex
+	err     error // last error from newInstance
+	stopped bool
+}
+
+// startPool starts filling a pool of size instances created by newInstance,
+// waiting retryDelay after the first failure.
+func startPool(size int, retryDelay time.Duration, newInstance func(context.Context) (*instance, error)) *instancePool {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &instancePool{
+		ready:  make(chan *instance, size),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go p.fill(ctx, retryDelay, newInstance)
+	return p
+}
+
+func (p *instancePool) fill(ctx context.Context, retryDelay time.Duration, newInstance func(context.Context) (*instance, error)) {
+	defer close(p.done)
+	failures, delay := 0, retryDelay
+	for {
+		inst, err := newInstance(ctx)
+		p.setErr(err, false)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if failures++; failures >= poolMaxFailures {
+				p.setErr(err, true)
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+				delay = min(2*delay, poolMaxRetryDelay)
+				continue
+			}
+		}
+		failures, delay = 0, retryDelay
+		select {
+		case p.ready <- inst:
+		case <-ctx.Done():
+			inst.close(context.Background())
+			return
+		}
+	}
+}
+
+// get returns a pre-warmed instance, or nil if none is ready.
+func (p *instancePool) get() *instance {
+	select {
+	case inst := <-p.ready:
+		p.hits.Add(1)
+		return inst
+	default:
+		p.misses.Add(1)
+		return nil
+	}
+}
+
+// setErr records the outcome of creating an instance.
+func (p *instancePool) setErr(err error, stopped bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err, p.stopped = err, stopped
+}
+
+func (p *instancePool) stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PoolStats{
+		Hits:     p.hits.Load(),
+		Misses:   p.misses.Load(),
+		Bypassed: p.bypassed.Load(),
+		Idle:     len(p.ready),
+		Err:      p.err,
+		Stopped:  p.stopped,
+	}
+}
+
+// close stops refilling and releases the idle instances.
+func (p *instancePool) close(ctx context.Context) {
+	p.cancel()
+	<-p.done
+	for {
+		select {
+		case inst := <-p.ready:
+			inst.close(ctx)
+		default:
+			return
+		}
+	}
+}
+
+// PoolStats returns the instance pool's counters. It returns the zero
+// PoolStats if the Compiler was created without WithInstancePool.
+func (c *Compiler) PoolStats() PoolStats {
+	if c.pool == nil {
+		return PoolStats{}
+	}
+	return c.pool.stats()
+}
+
+// poolable reports whether a compilation can run on a pooled instance: it
+// must mount exactly the Compiler's defaults and set no limit that has to be
+// in place when the module is instantiated.
+func (c *Compiler) poolable(cfg compileConfig, in compileInput) bool {
+	return in.fsys == nil &&
+		!cfg.bundleSet &&
+		len(cfg.overlays) == 0 &&
+		cfg.fontsDir == c.config.defaultFontsDir &&
+		cfg.inMemory == c.config.inMemory &&
+		cfg.limits.maxMemory == 0 &&
+		cfg.limits.maxOutputFiles == 0 &&
+		cfg.limits.fuelBudget == 0
+}
+
+// newPooledInstance creates an instance for the pool.
+func (c *Compiler) newPooledInstance(ctx context.Context) (*instance, error) {
+	ws, err := newWorkspace(c.config.inMemory, "tecgonic-*")
+	if err != nil {
+		return nil, err
+	}
+	inst, err := c.instantiate(ctx, c.compiled, ws, mounts{
+		fontsDir:  c.config.defaultFontsDir,
+		bundleDir: c.config.defaultBundleDir,
+		bundleFS:  c.config.defaultBundleFS,
+		overlay:   c.overlay,
+	})
+	if err != nil {
+		ws.close()
+		return nil, err
+	}
+	return inst, nil
+}
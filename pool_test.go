@@ -0,0 +1,109 @@
+package tecgonic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// emptyModule is the smallest valid WebAssembly module.
+var emptyModule = []byte("\x00asm\x01\x00\x00\x00")
+
+func TestInstancePool(t *testing.T) {
+	ctx := context.Background()
+	rt := wazero.NewRuntime(ctx)
+	defer func() { _ = rt.Close(ctx) }()
+	compiled, err := rt.CompileModule(ctx, emptyModule)
+	if err != nil {
+		t.Fatalf("CompileModule: %v", err)
+	}
+
+	created := make(chan struct{}, 10)
+	p := startPool(2, poolRetryDelay, func(ctx context.Context) (*instance, error) {
+		ws, err := newWorkspace(true, "")
+		if err != nil {
+			return nil, err
+		}
+		mod, err := rt.InstantiateModule(ctx, compiled, wazero.NewModuleConfig().WithName(""))
+		if err != nil {
+			return nil, err
+		}
+		created <- struct{}{}
+		return &instance{mod: mod, ws: ws, stderr: &switchWriter{}}, nil
+	})
+
+	waitIdle := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for p.stats().Idle != n {
+			if time.Now().After(deadline) {
+				t.Fatalf("pool has %d idle instances, want %d", p.stats().Idle, n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	waitIdle(2)
+	if inst := p.get(); inst == nil {
+		t.Fatal("get returned nil from a full pool")
+	} else {
+		inst.close(ctx)
+	}
+	waitIdle(2)
+	p.close(ctx)
+
+	if got := len(created); got < 3 {
+		t.Errorf("created %d instances, want at least 3", got)
+	}
+	stats := p.stats()
+	if stats.Hits != 1 || stats.Misses != 0 || stats.Idle != 0 {
+		t.Errorf("stats = %+v, want 1 hit, 0 misses, 0 idle", stats)
+	}
+}
+
+func TestInstancePoolEmpty(t *testing.T) {
+	errEngine := errors.New("no engine")
+	var calls atomic.Int32
+	p := startPool(1, time.Millisecond, func(context.Context) (*instance, error) {
+		calls.Add(1)
+		return nil, errEngine
+	})
+	defer p.close(context.Background())
+
+	if inst := p.get(); inst != nil {
+		t.Fatal("get returned an instance from an empty pool")
+	}
+	if got := p.stats().Misses; got != 1 {
+		t.Errorf("Misses = %d, want 1", got)
+	}
+
+	select {
+	case <-p.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pool kept retrying after repeated failures")
+	}
+	if got := calls.Load(); got != poolMaxFailures {
+		t.Errorf("newInstance called %d times, want %d", got, poolMaxFailures)
+	}
+	if stats := p.stats(); !stats.Stopped || !errors.Is(stats.Err, errEngine) {
+		t.Errorf("stats = %+v, want stopped with %v", stats, errEngine)
+	}
+}
+
+func TestSwitchWriter(t *testing.T) {
+	var s switchWriter
+	if n, err := s.Write([]byte("dropped")); n != 7 || err != nil {
+		t.Fatalf("Write without writer = %d, %v", n, err)
+	}
+	var buf bytes.Buffer
+	s.set(&buf)
+	_, _ = s.Write([]byte("kept"))
+	if got := buf.String(); got != "kept" {
+		t.Errorf("got %q, want %q", got, "kept")
+	}
+}
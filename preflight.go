@@ -0,0 +1,196 @@
+package tecgonic
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// PreflightReport is the result of checking a document's references before
+// compiling it. See Compiler.Preflight.
+type PreflightReport struct {
+	Checked int                // references checked
+	Missing []MissingReference // references that could not be resolved
+}
+
+// OK reports whether every reference was resolved.
+func (r *PreflightReport) OK() bool {
+	return len(r.Missing) == 0
+}
+
+// MissingReference is a file a document references that exists neither in
+// the project nor in the bundle.
+type MissingReference struct {
+	Kind string // "class", "package", "input" or "graphic"
+	Name string // the name as written, e.g. "amsmath" or "figures/plot"
+	File string // the project file holding the reference
+	Line int
+}
+
+func (m MissingReference) String() string {
+	return fmt.Sprintf("%s:%d: %s %s not found", m.File, m.Line, m.Kind, m.Name)
+}
+
+func (k refKind) String() string {
+	switch k {
+	case refClass:
+		return "class"
+	case refPackage:
+		return "package"
+	case refGraphic:
+		return "graphic"
+	}
+	return "input"
+}
+
+// Preflight checks, without running the engine, that the classes, packages,
+// \input and \include files and graphics that texSource references exist in
+// the bundle or its overlays. It takes the same options as Compile and fails
+// like Compile if the bundle or its format file is missing.
+//
+// References are found by scanning the source, so files named through macros
+// are not checked. Files loaded with \InputIfFileExists or tested with
+// \IfFileExists may be absent, and graphics are also looked up in the
+// directories set with \graphicspath. If any reference cannot be resolved,
+// the returned report lists them all and the error matches ErrMissingFile.
+func (c *Compiler) Preflight(ctx context.Context, texSource []byte, opts ...CompileOption) (*PreflightReport, error) {
+	return c.preflight(ctx, nil, "input.tex", texSource, opts)
+}
+
+// PreflightFS is like Preflight for a multi-file project compiled with
+// CompileFS: references resolve against fsys before the bundle, and project
+// files the document loads are checked in turn.
+func (c *Compiler) PreflightFS(ctx context.Context, fsys fs.FS, main string, opts ...CompileOption) (*PreflightReport, error) {
+	if err := checkMain(fsys, main); err != nil {
+		return nil, err
+	}
+	src, err := fs.ReadFile(fsys, main)
+	if err != nil {
+		return nil, fmt.Errorf("tecgonic: main file: %w", err)
+	}
+	return c.preflight(ctx, fsys, main, src, opts)
+}
+
+func (c *Compiler) preflight(ctx context.Context, project fs.FS, main string, src []byte, opts []CompileOption) (*PreflightReport, error) {
+	cfg := c.configure(opts)
+	if err := checkBundle(cfg.bundleDir, cfg.bundleFS); err != nil {
+		return nil, err
+	}
+	overlay, err := c.overlays(cfg)
+	if err != nil {
+		return nil, err
+	}
+	bundle := mounts{bundleDir: cfg.bundleDir, bundleFS: cfg.bundleFS, overlay: overlay}.bundleMount()
+	if bundle == nil {
+		bundle = os.DirFS(cfg.bundleDir)
+	}
+	return preflight(ctx, project, bundle, main, src)
+}
+
+// preflight checks the references of src, the project file main, against
+// project, which may be nil, and bundle.
+func preflight(ctx context.Context, project, bundle fs.FS, main string, src []byte) (*PreflightReport, error) {
+	type source struct {
+		file string
+		data []byte
+	}
+	type graphic struct {
+		ref  texRef
+		file string
+	}
+	report := &PreflightReport{}
+	seen := map[string]bool{main: true}
+	queue := []source{{main, src}}
+	var graphics []graphic
+	var graphicsPath []string
+
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("tecgonic: preflight: %w", err)
+		}
+		s := queue[0]
+		queue = queue[1:]
+
+		for _, ref := range scanRefs(s.data) {
+			report.Checked++
+			if ref.kind == refGraphic {
+				// Resolved once \graphicspath is known from every source
+				graphics = append(graphics, graphic{ref, s.file})
+				continue
+			}
+			name, inProject := resolveRef(project, bundle, ref, nil)
+			switch {
+			case name == "" && ref.kind == refOptional:
+				// The document handles the file being absent
+			case name == "":
+				report.Missing = append(report.Missing, MissingReference{Kind: ref.kind.String(), Name: ref.name, File: s.file, Line: ref.line})
+			case inProject && !seen[name]:
+				// Check the references of project files too
+				seen[name] = true
+				data, err := fs.ReadFile(project, name)
+				if err != nil {
+					return nil, fmt.Errorf("tecgonic: preflight: %w", err)
+				}
+				queue = append(queue, source{name, data})
+			}
+		}
+		graphicsPath = append(graphicsPath, scanGraphicsPath(s.data)...)
+	}
+
+	for _, g := range graphics {
+		if name, _ := resolveRef(project, bundle, g.ref, graphicsPath); name == "" {
+			report.Missing = append(report.Missing, MissingReference{Kind: g.ref.kind.String(), Name: g.ref.name, File: g.file, Line: g.ref.line})
+		}
+	}
+
+	if !report.OK() {
+		return report, fmt.Errorf("tecgonic: %s: %w", report.summary(), ErrMissingFile)
+	}
+	return report, nil
+}
+
+// resolveRef returns the file ref resolves to, looking in project first, and
+// whether it is a project file. Names are tried as given, then in each of
+// dirs. It returns "" if ref cannot be resolved.
+func resolveRef(project, bundle fs.FS, ref texRef, dirs []string) (string, bool) {
+	for _, dir := range append([]string{""}, dirs...) {
+		for _, name := range ref.candidates() {
+			name = path.Join(dir, name)
+			if !fs.ValidPath(name) {
+				continue
+			}
+			if project != nil && isFile(project, name) {
+				return name, true
+			}
+			if isFile(bundle, name) {
+				return name, false
+			}
+		}
+	}
+	return "", false
+}
+
+func isFile(fsys fs.FS, name string) bool {
+	info, err := fs.Stat(fsys, name)
+	return err == nil && !info.IsDir()
+}
+
+// summary describes the missing references in a failed report.
+func (r *PreflightReport) summary() string {
+	names := make([]string, 0, 3)
+	for _, m := range r.Missing[:min(3, len(r.Missing))] {
+		names = append(names, m.Kind+" "+m.Name)
+	}
+	noun := "references"
+	if len(r.Missing) == 1 {
+		noun = "reference"
+	}
+	s := fmt.Sprintf("%d unresolved %s (%s", len(r.Missing), noun, strings.Join(names, ", "))
+	if len(r.Missing) > 3 {
+		s += ", ..."
+	}
+	return s + ")"
+}
@@ -0,0 +1,109 @@
+package tecgonic
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+var preflightBundle = fstest.MapFS{
+	"latex.fmt":    {Data: []byte("fmt")},
+	"article.cls":  {Data: []byte("% article\n")},
+	"amsmath.sty":  {Data: []byte("% amsmath\n")},
+	"graphicx.sty": {Data: []byte("% graphicx\n")},
+}
+
+func TestPreflight(t *testing.T) {
+	src := []byte(`\documentclass{article}
+\usepackage{amsmath,missingpkg}
+\begin{document}
+\input{nochapter}
+\end{document}
+`)
+	report, err := preflight(context.Background(), nil, preflightBundle, "input.tex", src)
+	if !errors.Is(err, ErrMissingFile) {
+		t.Fatalf("preflight = %v, want ErrMissingFile", err)
+	}
+	want := []MissingReference{
+		{Kind: "package", Name: "missingpkg", File: "input.tex", Line: 2},
+		{Kind: "input", Name: "nochapter", File: "input.tex", Line: 4},
+	}
+	if !slices.Equal(report.Missing, want) || report.Checked != 4 {
+		t.Errorf("report = %+v, want missing %v of 4", report, want)
+	}
+	if report.OK() {
+		t.Error("report OK with missing references")
+	}
+}
+
+func TestPreflightProject(t *testing.T) {
+	project := fstest.MapFS{
+		"main.tex": {Data: []byte(`\documentclass{corp}
+\usepackage{graphicx}
+\include{chapters/one}
+\includegraphics{figures/plot}
+`)},
+		"corp.cls":         {Data: []byte("\\LoadClass{article}\n\\RequirePackage{corplogo}\n")},
+		"chapters/one.tex": {Data: []byte("\\input{chapters/one}\n\\includegraphics{figures/missing.png}\n")},
+		"figures/plot.pdf": {Data: []byte("%PDF")},
+	}
+
+	report, err := preflight(context.Background(), project, preflightBundle, "main.tex", project["main.tex"].Data)
+	if !errors.Is(err, ErrMissingFile) {
+		t.Fatalf("preflight = %v, want ErrMissingFile", err)
+	}
+	want := []MissingReference{
+		{Kind: "package", Name: "corplogo", File: "corp.cls", Line: 2},
+		{Kind: "graphic", Name: "figures/missing.png", File: "chapters/one.tex", Line: 2},
+	}
+	if !slices.Equal(report.Missing, want) {
+		t.Errorf("missing = %v, want %v", report.Missing, want)
+	}
+
+	project["corplogo.sty"] = &fstest.MapFile{Data: []byte("% logo\n")}
+	project["figures/missing.png"] = &fstest.MapFile{Data: []byte("png")}
+	if report, err := preflight(context.Background(), project, preflightBundle, "main.tex", project["main.tex"].Data); err != nil || !report.OK() {
+		t.Errorf("preflight = %+v, %v; want OK", report, err)
+	}
+}
+
+func TestPreflightOptionalAndGraphicsPath(t *testing.T) {
+	project := fstest.MapFS{
+		"main.tex": {Data: []byte(`\documentclass{article}
+\usepackage{graphicx}
+\InputIfFileExists{local.cfg}{}{}
+\IfFileExists{logo.pdf}{}{}
+\input{setup}
+\includegraphics{plot}
+\includegraphics{img/logo}
+`)},
+		"setup.tex":        {Data: []byte("\\graphicspath{{figures/}{img/}}\n")},
+		"figures/plot.pdf": {Data: []byte("%PDF")},
+	}
+
+	report, err := preflight(context.Background(), project, preflightBundle, "main.tex", project["main.tex"].Data)
+	if !errors.Is(err, ErrMissingFile) {
+		t.Fatalf("preflight = %v, want ErrMissingFile", err)
+	}
+	want := []MissingReference{
+		{Kind: "graphic", Name: "img/logo", File: "main.tex", Line: 7},
+	}
+	if !slices.Equal(report.Missing, want) {
+		t.Errorf("missing = %v, want %v", report.Missing, want)
+	}
+
+	project["img/logo.png"] = &fstest.MapFile{Data: []byte("png")}
+	if report, err := preflight(context.Background(), project, preflightBundle, "main.tex", project["main.tex"].Data); err != nil || !report.OK() {
+		t.Errorf("preflight = %+v, %v; want OK", report, err)
+	}
+}
+
+func TestPreflightCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := preflight(ctx, nil, preflightBundle, "input.tex", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("preflight = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,192 @@
+package tecgonic
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ProgressPhase is the stage of bundle preparation a ProgressEvent reports on.
+type ProgressPhase int
+
+const (
+	// PhaseDownload is downloading a bundle file.
+	PhaseDownload ProgressPhase = iota
+	// PhaseRead is reading a bundle given to InstallBundle.
+	PhaseRead
+	// PhaseExtract is writing the bundle's files to disk.
+	PhaseExtract
+	// PhaseVerify is checking the extracted files with VerifyBundle.
+	PhaseVerify
+	// PhaseFetch is a LazyBundle fetching one file.
+	PhaseFetch
+)
+
+func (p ProgressPhase) String() string {
+	switch p {
+	case PhaseDownload:
+		return "download"
+	case PhaseRead:
+		return "read"
+	case PhaseExtract:
+		return "extract"
+	case PhaseVerify:
+		return "verify"
+	case PhaseFetch:
+		return "fetch"
+	}
+	return fmt.Sprintf("ProgressPhase(%d)", int(p))
+}
+
+// ProgressEvent reports progress in preparing a bundle.
+type ProgressEvent struct {
+	Phase ProgressPhase
+
+	// Bytes and TotalBytes count the bytes of the bundle downloaded or read,
+	// or of the file fetched. TotalBytes is -1 if unknown.
+	Bytes, TotalBytes int64
+
+	// Files and TotalFiles count the files extracted. TotalFiles is -1 if
+	// unknown.
+	Files, TotalFiles int
+
+	// Entry is the bundle file just extracted or fetched.
+	Entry string
+
+	// ETA estimates the time left in the phase, 0 if unknown.
+	ETA time.Duration
+
+	// Done is set on the last event of a phase.
+	Done bool
+
+	// Err is a failed download attempt, to be retried after RetryIn.
+	Err     error
+	RetryIn time.Duration
+}
+
+// ProgressFunc receives progress events. PrepareBundle and InstallBundle call
+// it from one goroutine at a time; a LazyBundle may call it concurrently.
+type ProgressFunc func(ProgressEvent)
+
+// WithProgressFunc reports progress as events to fn, replacing any
+// WithProgress writer.
+func WithProgressFunc(fn ProgressFunc) PrepareBundleOption {
+	return func(c *prepareBundleConfig) {
+		c.progress = fn
+	}
+}
+
+// report calls f if it is set.
+func (f ProgressFunc) report(ev ProgressEvent) {
+	if f != nil {
+		f(ev)
+	}
+}
+
+// TextProgress returns a ProgressFunc writing progress to w as text lines:
+// download progress every 10 MB and extraction progress every 10000 files.
+// It is what WithProgress uses.
+func TextProgress(w io.Writer) ProgressFunc {
+	var (
+		mu    sync.Mutex
+		last  int64 // bytes at the last download line
+		phase ProgressPhase
+	)
+	return func(ev ProgressEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		if ev.Phase != phase {
+			phase, last = ev.Phase, 0
+		}
+		switch ev.Phase {
+		case PhaseDownload, PhaseRead:
+			if ev.Err != nil {
+				_, _ = fmt.Fprintf(w, "  Retrying in %s: %v\n", ev.RetryIn, ev.Err)
+				return
+			}
+			if ev.Bytes < last {
+				last = 0
+			}
+			if ev.Bytes-last < 10*1024*1024 {
+				return
+			}
+			last = ev.Bytes - ev.Bytes%(10*1024*1024)
+			verb := "Downloading"
+			if ev.Phase == PhaseRead {
+				verb = "Reading"
+			}
+			mb := ev.Bytes / (1024 * 1024)
+			if ev.TotalBytes > 0 {
+				totalMB := ev.TotalBytes / (1024 * 1024)
+				pct := ev.Bytes * 100 / ev.TotalBytes
+				_, _ = fmt.Fprintf(w, "  %s: %d / %d MB (%d%%)\n", verb, mb, totalMB, pct)
+			} else {
+				_, _ = fmt.Fprintf(w, "  %s: %d MB\n", verb, mb)
+			}
+		case PhaseExtract:
+			switch {
+			case ev.Done:
+				_, _ = fmt.Fprintf(w, "  Extracted %d files (done)\n", ev.Files)
+			case ev.Files%10000 == 0:
+				_, _ = fmt.Fprintf(w, "  Extracted %d files\n", ev.Files)
+			}
+		case PhaseFetch:
+			if ev.Done {
+				_, _ = fmt.Fprintf(w, "  Fetched %s (%d bytes)\n", ev.Entry, ev.Bytes)
+			}
+		}
+	}
+}
+
+// progressInterval is how many bytes are read between download events.
+const progressInterval = 1024 * 1024
+
+// progressReader wraps an io.Reader and reports bytes read every
+// progressInterval.
+type progressReader struct {
+	r      io.Reader
+	phase  ProgressPhase
+	total  int64 // -1 if unknown
+	read   int64
+	last   int64 // byte count at the last event
+	report ProgressFunc
+
+	start     time.Time
+	startRead int64 // byte count at start
+}
+
+func newProgressReader(r io.Reader, phase ProgressPhase, offset, total int64, report ProgressFunc) *progressReader {
+	return &progressReader{r: r, phase: phase, total: total, read: offset, last: offset, report: report, start: time.Now(), startRead: offset}
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.read += int64(n)
+	if pr.read-pr.last >= progressInterval {
+		pr.last = pr.read
+		pr.report.report(pr.event(false))
+	}
+	return n, err
+}
+
+// event returns the current progress. Once done, the total is known even if
+// it was not before.
+func (pr *progressReader) event(done bool) ProgressEvent {
+	ev := ProgressEvent{Phase: pr.phase, Bytes: pr.read, TotalBytes: pr.total, Done: done}
+	if done {
+		ev.TotalBytes = pr.read
+	} else if pr.total >= 0 {
+		ev.ETA = eta(time.Since(pr.start), pr.read-pr.startRead, pr.total-pr.read)
+	}
+	return ev
+}
+
+// eta estimates the time to process remaining units at the rate done units
+// were processed in elapsed.
+func eta(elapsed time.Duration, done, remaining int64) time.Duration {
+	if done <= 0 || remaining <= 0 {
+		return 0
+	}
+	return time.Duration(float64(elapsed) / float64(done) * float64(remaining))
+}
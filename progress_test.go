@@ -0,0 +1,86 @@
+package tecgonic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTextProgress(t *testing.T) {
+	var buf bytes.Buffer
+	report := TextProgress(&buf)
+	const mb = 1024 * 1024
+	for _, n := range []int64{1, 9, 10, 11, 19, 20} {
+		report(ProgressEvent{Phase: PhaseDownload, Bytes: n * mb, TotalBytes: 40 * mb})
+	}
+	report(ProgressEvent{Phase: PhaseDownload, TotalBytes: -1, Err: errors.New("reset"), RetryIn: time.Second})
+	report(ProgressEvent{Phase: PhaseExtract, Files: 10000, TotalFiles: -1})
+	report(ProgressEvent{Phase: PhaseExtract, Files: 10001, TotalFiles: -1})
+	report(ProgressEvent{Phase: PhaseExtract, Files: 12000, TotalFiles: 12000, Done: true})
+	report(ProgressEvent{Phase: PhaseFetch, Entry: "article.cls", Bytes: 42, TotalBytes: 42, Done: true})
+
+	want := strings.Join([]string{
+		"  Downloading: 10 / 40 MB (25%)",
+		"  Downloading: 20 / 40 MB (50%)",
+		"  Retrying in 1s: reset",
+		"  Extracted 10000 files",
+		"  Extracted 12000 files (done)",
+		"  Fetched article.cls (42 bytes)",
+	}, "\n") + "\n"
+	if buf.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestPrepareBundleProgressEvents(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []ProgressEvent
+	)
+	dest := filepath.Join(t.TempDir(), "bundle")
+	err := PrepareBundle(context.Background(), dest, serveBundle(t, testBundle(t, 120), 0).URL, false,
+		WithVerify(""), WithProgressFunc(func(ev ProgressEvent) {
+			mu.Lock()
+			events = append(events, ev)
+			mu.Unlock()
+		}))
+	if err != nil {
+		t.Fatalf("PrepareBundle: %v", err)
+	}
+
+	var phases []ProgressPhase
+	files := 0
+	for _, ev := range events {
+		if len(phases) == 0 || phases[len(phases)-1] != ev.Phase {
+			phases = append(phases, ev.Phase)
+		}
+		switch {
+		case ev.Phase == PhaseDownload && ev.Done:
+			if ev.TotalBytes <= 0 || ev.Bytes != ev.TotalBytes {
+				t.Errorf("download done at %d of %d bytes", ev.Bytes, ev.TotalBytes)
+			}
+		case ev.Phase == PhaseExtract && !ev.Done:
+			if ev.Files != files+1 || ev.TotalFiles != 121 || ev.Entry == "" {
+				t.Errorf("extract event %+v after %d files", ev, files)
+			}
+			files = ev.Files
+		}
+	}
+	want := []ProgressPhase{PhaseDownload, PhaseExtract, PhaseVerify}
+	if len(phases) != len(want) {
+		t.Fatalf("phases = %v, want %v", phases, want)
+	}
+	for i := range want {
+		if phases[i] != want[i] {
+			t.Fatalf("phases = %v, want %v", phases, want)
+		}
+	}
+	if last := events[len(events)-1]; last.Phase != PhaseVerify || !last.Done {
+		t.Errorf("last event = %+v, want verify done", last)
+	}
+}
@@ -0,0 +1,110 @@
+package tecgonic
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Lookup finds the bundle file TeX would load for name, the way kpsewhich
+// does: name with .tex appended if it has no extension, then name as given.
+// Use "name.sty" or "name.cls" to look up packages and classes.
+func (b *Bundle) Lookup(name string) (string, bool) {
+	for _, candidate := range inputCandidates(name) {
+		if b.has(candidate) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// has reports whether the bundle holds a file called name.
+func (b *Bundle) has(name string) bool {
+	_, found := slices.BinarySearch(b.names, name)
+	return found
+}
+
+// Search returns the bundle files whose names match pattern, sorted. The
+// pattern syntax is that of path.Match, e.g. "*.cls" or "tikz*".
+func (b *Bundle) Search(pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("tecgonic: searching bundle: %w", err)
+	}
+	var matches []string
+	for _, name := range b.names {
+		if ok, _ := path.Match(pattern, name); ok {
+			matches = append(matches, name)
+		}
+	}
+	return matches, nil
+}
+
+// PackageFiles returns the files belonging to the LaTeX package or class
+// pkg, sorted: pkg.sty or pkg.cls, other files named pkg such as pkg.cfg,
+// .cfg, .def and .code.tex files named pkg-something such as pkg-utils.def,
+// and files its main file loads with \input or \InputIfFileExists. Other
+// pkg-something files, and those of a package pkg-something such as tikz-cd
+// for tikz, belong to separate packages. Packages it loads with
+// \RequirePackage are dependencies, not part of it, and are not listed. The
+// error matches fs.ErrNotExist if the bundle has no such package.
+func (b *Bundle) PackageFiles(pkg string) ([]string, error) {
+	var mains []string
+	for _, ext := range []string{".sty", ".cls"} {
+		if b.has(pkg + ext) {
+			mains = append(mains, pkg+ext)
+		}
+	}
+	if len(mains) == 0 {
+		return nil, fmt.Errorf("tecgonic: no package %s in bundle: %w", pkg, fs.ErrNotExist)
+	}
+
+	files := make(map[string]bool)
+	for _, name := range b.names {
+		base, _, _ := strings.Cut(name, ".")
+		if base == pkg || strings.HasPrefix(base, pkg+"-") && isPackageAux(name) && !b.isPackage(base) {
+			files[name] = true
+		}
+	}
+	for _, main := range mains {
+		src, err := fs.ReadFile(b.fsys, main)
+		if err != nil {
+			return nil, fmt.Errorf("tecgonic: reading %s: %w", main, err)
+		}
+		for _, ref := range scanRefs(src) {
+			if ref.kind != refInput {
+				continue
+			}
+			if name, ok := b.Lookup(ref.name); ok {
+				files[name] = true
+			}
+		}
+	}
+
+	list := make([]string, 0, len(files))
+	for name := range files {
+		list = append(list, name)
+	}
+	slices.Sort(list)
+	return list, nil
+}
+
+// isPackage reports whether the bundle has a package or class named name.
+func (b *Bundle) isPackage(name string) bool {
+	return b.has(name+".sty") || b.has(name+".cls")
+}
+
+// packageAuxExtensions are the extensions of the files a package splits its
+// code and configuration into.
+var packageAuxExtensions = []string{".cfg", ".def", ".code.tex"}
+
+// isPackageAux reports whether name has one of packageAuxExtensions.
+func isPackageAux(name string) bool {
+	for _, ext := range packageAuxExtensions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
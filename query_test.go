@@ -0,0 +1,86 @@
+package tecgonic
+
+import (
+	"errors"
+	"io/fs"
+	"slices"
+	"testing"
+)
+
+// openQueryBundle opens a bundle file holding a small package.
+func openQueryBundle(t *testing.T) *Bundle {
+	t.Helper()
+	b, err := OpenBundle(writeITar(t, map[string]string{
+		"SVNREV":           "1\n",
+		"widget.sty":       "\\RequirePackage{xcolor}\n\\input{widgetparts.def}\n\\InputIfFileExists{widget.cfg}{}{}\n",
+		"widget.cfg":       "% config\n",
+		"widget-ext.def":   "% definitions\n",
+		"tikz.sty":         "\\input{tikz.code.tex}\n",
+		"tikz.code.tex":    "% tikz\n",
+		"tikz-cd.sty":      "% commutative diagrams\n",
+		"tikz-cd.code.tex": "% tikz-cd\n",
+		"widgetparts.def":  "% parts\n",
+		"xcolor.sty":       "% xcolor\n",
+		"article.cls":      "% article\n",
+		"plain.tex":        "% plain\n",
+		"fontmap":          "% no extension\n",
+	}))
+	if err != nil {
+		t.Fatalf("OpenBundle: %v", err)
+	}
+	t.Cleanup(func() { _ = b.Close() })
+	return b
+}
+
+func TestBundleLookup(t *testing.T) {
+	b := openQueryBundle(t)
+	for name, want := range map[string]string{
+		"plain":       "plain.tex",
+		"plain.tex":   "plain.tex",
+		"fontmap":     "fontmap",
+		"article.cls": "article.cls",
+		"article":     "",
+		"missing.sty": "",
+	} {
+		got, ok := b.Lookup(name)
+		if got != want || ok != (want != "") {
+			t.Errorf("Lookup(%q) = %q, %v; want %q", name, got, ok, want)
+		}
+	}
+}
+
+func TestBundleSearch(t *testing.T) {
+	b := openQueryBundle(t)
+	got, err := b.Search("widget*.sty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"widget.sty"}; !slices.Equal(got, want) {
+		t.Errorf("Search = %v, want %v", got, want)
+	}
+	if _, err := b.Search("[bad"); err == nil {
+		t.Error("Search accepted a malformed pattern")
+	}
+}
+
+func TestBundlePackageFiles(t *testing.T) {
+	b := openQueryBundle(t)
+	got, err := b.PackageFiles("widget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"widget-ext.def", "widget.cfg", "widget.sty", "widgetparts.def"}; !slices.Equal(got, want) {
+		t.Errorf("PackageFiles = %v, want %v", got, want)
+	}
+	// tikz-cd is a package of its own.
+	got, err = b.PackageFiles("tikz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"tikz.code.tex", "tikz.sty"}; !slices.Equal(got, want) {
+		t.Errorf("PackageFiles(tikz) = %v, want %v", got, want)
+	}
+	if _, err := b.PackageFiles("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("PackageFiles(missing) = %v, want fs.ErrNotExist", err)
+	}
+}
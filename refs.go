@@ -0,0 +1,154 @@
+package tecgonic
+
+import (
+	"bytes"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// refKind is the kind of file a TeX source references.
+type refKind int
+
+const (
+	refClass    refKind = iota // \documentclass, \LoadClass
+	refPackage                 // \usepackage, \RequirePackage
+	refInput                   // \input, \include
+	refOptional                // \InputIfFileExists, \IfFileExists
+	refGraphic                 // \includegraphics
+)
+
+// texRef is a file referenced by a TeX source.
+type texRef struct {
+	kind refKind
+	name string
+	line int
+}
+
+// refPattern matches the commands that load files, with their optional
+// arguments and either a braced argument or, for \input, a bare file name.
+// Longer names come first, so \includegraphics is not read as \include.
+var refPattern = regexp.MustCompile(`\\(documentclass|LoadClass|usepackage|RequirePackage|includegraphics|include|InputIfFileExists|IfFileExists|input)\*?\s*(?:\[[^\]]*\]\s*)*(?:\{([^}]*)\}|([^\s{}\\%]+))`)
+
+// scanRefs returns the files src references, in order. Comments are skipped.
+// References built from macros, such as \input{\jobname.aux}, are ignored.
+func scanRefs(src []byte) []texRef {
+	var refs []texRef
+	for i, line := range bytes.Split(src, []byte("\n")) {
+		line = stripComment(line)
+		for _, m := range refPattern.FindAllSubmatchIndex(line, -1) {
+			// Skip longer commands, such as \inputlineno
+			if end := m[3]; end < len(line) && isLetter(line[end]) {
+				continue
+			}
+			cmd, arg := string(line[m[2]:m[3]]), ""
+			if m[4] >= 0 {
+				arg = string(line[m[4]:m[5]])
+			}
+			if m[6] >= 0 {
+				if cmd != "input" {
+					continue
+				}
+				arg = string(line[m[6]:m[7]])
+			}
+			var kind refKind
+			switch cmd {
+			case "documentclass", "LoadClass":
+				kind = refClass
+			case "usepackage", "RequirePackage":
+				kind = refPackage
+			case "includegraphics":
+				kind = refGraphic
+			case "InputIfFileExists", "IfFileExists":
+				kind = refOptional
+			default:
+				kind = refInput
+			}
+			for _, name := range strings.Split(arg, ",") {
+				name = strings.TrimSpace(name)
+				if name == "" || strings.ContainsAny(name, `\#`) {
+					continue
+				}
+				refs = append(refs, texRef{kind: kind, name: name, line: i + 1})
+			}
+		}
+	}
+	return refs
+}
+
+// graphicsPathPattern matches \graphicspath and its list of braced
+// directories.
+var (
+	graphicsPathPattern = regexp.MustCompile(`\\graphicspath\s*\{((?:\s*\{[^{}]*\})*)\s*\}`)
+	bracedPattern       = regexp.MustCompile(`\{([^{}]*)\}`)
+)
+
+// scanGraphicsPath returns the directories src adds to the graphics search
+// path with \graphicspath, in order. Comments are skipped.
+func scanGraphicsPath(src []byte) []string {
+	var dirs []string
+	for _, line := range bytes.Split(src, []byte("\n")) {
+		line = stripComment(line)
+		for _, m := range graphicsPathPattern.FindAllSubmatch(line, -1) {
+			for _, d := range bracedPattern.FindAllSubmatch(m[1], -1) {
+				if dir := strings.TrimSpace(string(d[1])); dir != "" && !strings.Contains(dir, `\`) {
+					dirs = append(dirs, dir)
+				}
+			}
+		}
+	}
+	return dirs
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '@'
+}
+
+// stripComment removes a TeX comment from line.
+func stripComment(line []byte) []byte {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++ // skip the escaped character, e.g. \%
+		case '%':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// graphicExtensions are tried, in order, for \includegraphics names without
+// an extension.
+var graphicExtensions = []string{".pdf", ".png", ".jpg", ".jpeg", ".eps"}
+
+// candidates returns the file names a reference may resolve to, in the
+// order TeX tries them.
+func (r texRef) candidates() []string {
+	switch r.kind {
+	case refClass:
+		return []string{r.name + ".cls"}
+	case refPackage:
+		return []string{r.name + ".sty"}
+	case refGraphic:
+		if path.Ext(r.name) != "" {
+			return []string{r.name}
+		}
+		names := make([]string, len(graphicExtensions))
+		for i, ext := range graphicExtensions {
+			names[i] = r.name + ext
+		}
+		return names
+	default:
+		return inputCandidates(r.name)
+	}
+}
+
+// inputCandidates returns the names \input tries for name, the way kpsewhich
+// looks up TeX files: with .tex appended if name has no extension, then as
+// given.
+func inputCandidates(name string) []string {
+	if path.Ext(name) == "" {
+		return []string{name + ".tex", name}
+	}
+	return []string{name}
+}
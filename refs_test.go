@@ -0,0 +1,69 @@
+package tecgonic
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestScanRefs(t *testing.T) {
+	src := []byte(`\documentclass[11pt]{article}
+\usepackage[utf8]{inputenc}
+\usepackage{amsmath, graphicx}% \usepackage{commented}
+% \usepackage{skipped}
+\RequirePackage{xcolor}
+\input{chapter1}
+\input chapter2.tex
+\include{appendix}
+\InputIfFileExists{local.cfg}{}{}\IfFileExists{logo.pdf}{}{}
+\includegraphics[width=\linewidth]{figures/plot}
+\input{\jobname.aux}
+\the\inputlineno \includeonly{appendix}
+100\% \usepackage{after-escape}
+`)
+	want := []texRef{
+		{refClass, "article", 1},
+		{refPackage, "inputenc", 2},
+		{refPackage, "amsmath", 3},
+		{refPackage, "graphicx", 3},
+		{refPackage, "xcolor", 5},
+		{refInput, "chapter1", 6},
+		{refInput, "chapter2.tex", 7},
+		{refInput, "appendix", 8},
+		{refOptional, "local.cfg", 9},
+		{refOptional, "logo.pdf", 9},
+		{refGraphic, "figures/plot", 10},
+		{refPackage, "after-escape", 13},
+	}
+	if got := scanRefs(src); !slices.Equal(got, want) {
+		t.Errorf("scanRefs:\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestScanGraphicsPath(t *testing.T) {
+	src := []byte(`\graphicspath{{figures/}{ ./img/ }}
+% \graphicspath{{commented/}}
+\graphicspath{ {\mydir/} {more/} }
+`)
+	want := []string{"figures/", "./img/", "more/"}
+	if got := scanGraphicsPath(src); !slices.Equal(got, want) {
+		t.Errorf("scanGraphicsPath = %q, want %q", got, want)
+	}
+}
+
+func TestRefCandidates(t *testing.T) {
+	for _, tc := range []struct {
+		ref  texRef
+		want []string
+	}{
+		{texRef{kind: refClass, name: "article"}, []string{"article.cls"}},
+		{texRef{kind: refPackage, name: "amsmath"}, []string{"amsmath.sty"}},
+		{texRef{kind: refInput, name: "chapter"}, []string{"chapter.tex", "chapter"}},
+		{texRef{kind: refInput, name: "local.cfg"}, []string{"local.cfg"}},
+		{texRef{kind: refGraphic, name: "plot.png"}, []string{"plot.png"}},
+		{texRef{kind: refGraphic, name: "plot"}, []string{"plot.pdf", "plot.png", "plot.jpg", "plot.jpeg", "plot.eps"}},
+	} {
+		if got := tc.ref.candidates(); !slices.Equal(got, tc.want) {
+			t.Errorf("%v candidates = %v, want %v", tc.ref, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package tecgonic
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// CompileResult holds everything produced by a single compilation.
+type CompileResult struct {
+	PDF      []byte            // compiled PDF, nil when streamed with WithOutput
+	Log      string            // TeX log (input.log), empty if the engine did not keep one
+	Files    map[string][]byte // every file left in the output directory, keyed by name
+	Stderr   string            // diagnostic output captured from tectonic
+	Duration time.Duration     // wall-clock time of the engine run
+	Pages    int               // number of pages in the PDF, 0 if it could not be determined
+	Fuel     uint64            // fuel consumed, 0 unless WithFuelBudget was used
+
+	// Diagnostics holds the warnings (and any non-fatal errors) reported by
+	// the engine, parsed from Log or, if the engine kept no log, from Stderr.
+	Diagnostics []Diagnostic
+}
+
+// Warnings returns the diagnostics of the compilation with warning severity.
+func (r *CompileResult) Warnings() []Diagnostic {
+	return filterWarnings(r.Diagnostics, nil)
+}
+
+var (
+	// logPagesRe matches the summary line TeX writes at the end of a run,
+	// e.g. "Output written on input.xdv (3 pages, 10240 bytes)."
+	logPagesRe = regexp.MustCompile(`Output written on [^\n]*?\((\d+) pages?`)
+
+	// pdfPagesRe matches the /Count entry of a page tree node.
+	pdfPagesRe = regexp.MustCompile(`<<[^<>]*?/Type\s*/Pages\b[^<>]*?>>`)
+	pdfCountRe = regexp.MustCompile(`/Count\s+(\d+)`)
+	pdfPageRe  = regexp.MustCompile(`/Type\s*/Page\b`)
+)
+
+// countPages determines the page count of a compiled document, preferring
+// the TeX log summary and falling back to scanning the PDF's page tree.
+func countPages(log string, pdf []byte) int {
+	if m := logPagesRe.FindStringSubmatch(log); m != nil {
+		if n, err := strconv.Atoi(m[1]); err == nil {
+			return n
+		}
+	}
+	return pdfPageCount(pdf)
+}
+
+// pdfPageCount returns the page count recorded in an uncompressed PDF page
+// tree: the largest /Count of any /Pages node (the root), or failing that the
+// number of /Page objects. It returns 0 when the page tree lives in
+// compressed object streams.
+func pdfPageCount(pdf []byte) int {
+	pages := 0
+	for _, dict := range pdfPagesRe.FindAll(pdf, -1) {
+		if m := pdfCountRe.FindSubmatch(dict); m != nil {
+			if n, err := strconv.Atoi(string(m[1])); err == nil && n > pages {
+				pages = n
+			}
+		}
+	}
+	if pages > 0 {
+		return pages
+	}
+	return len(pdfPageRe.FindAllIndex(pdf, -1))
+}
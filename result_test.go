@@ -0,0 +1,74 @@
+package tecgonic
+
+import "testing"
+
+func TestCountPages(t *testing.T) {
+	tests := []struct {
+		name string
+		log  string
+		pdf  string
+		want int
+	}{
+		{
+			name: "log summary",
+			log:  "...\nOutput written on input.xdv (3 pages, 10240 bytes).\n",
+			want: 3,
+		},
+		{
+			name: "log summary single page",
+			log:  "Output written on input.xdv (1 page, 512 bytes).",
+			pdf:  "<< /Type /Pages /Count 7 >>",
+			want: 1,
+		},
+		{
+			name: "page tree root",
+			pdf:  "1 0 obj\n<< /Type /Pages /Kids [2 0 R 5 0 R] /Count 12 >>\nendobj\n2 0 obj\n<< /Type /Pages /Count 4 /Parent 1 0 R >>\nendobj",
+			want: 12,
+		},
+		{
+			name: "page objects",
+			pdf:  "<< /Type /Page /Parent 1 0 R >> << /Type/Page /Parent 1 0 R >> << /Type /Pages >>",
+			want: 2,
+		},
+		{
+			name: "unknown",
+			pdf:  "%PDF-1.5 compressed object streams",
+			want: 0,
+		},
+	}
+	for _, tt := range tests {
+		if got := countPages(tt.log, []byte(tt.pdf)); got != tt.want {
+			t.Errorf("%s: countPages = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestCountPagesPDF counts the pages of a complete PDF when the log has no
+// summary line, as when the engine keeps no log.
+func TestCountPagesPDF(t *testing.T) {
+	pdf := `%PDF-1.5
+1 0 obj
+<< /Type /Catalog /Pages 2 0 R >>
+endobj
+2 0 obj
+<< /Type /Pages /Kids [3 0 R 4 0 R 5 0 R] /Count 3 >>
+endobj
+3 0 obj
+<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] >>
+endobj
+4 0 obj
+<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] >>
+endobj
+5 0 obj
+<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] >>
+endobj
+trailer
+<< /Root 1 0 R >>
+%%EOF
+`
+	for _, log := range []string{"", "This is XeTeX, Version 3.141592653\n(./input.tex)\n"} {
+		if got := countPages(log, []byte(pdf)); got != 3 {
+			t.Errorf("countPages(%q, pdf) = %d, want 3", log, got)
+		}
+	}
+}
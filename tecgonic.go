@@ -5,8 +5,12 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/mgilbir/tecgonic/wasm"
 	"github.com/tetratelabs/wazero"
@@ -20,6 +24,14 @@ type Compiler struct {
 	compiled wazero.CompiledModule
 	config   compilerConfig
 	cache    wazero.CompilationCache
+	overlay  overlayIndex // index of config.overlays
+
+	// metered is the fuel-instrumented module, compiled on first use.
+	meteredOnce sync.Once
+	metered     wazero.CompiledModule
+	meteredErr  error
+
+	pool *instancePool // nil without WithInstancePool
 }
 
 // New creates a new Compiler, initializing the WASM runtime and pre-compiling
@@ -30,6 +42,11 @@ func New(ctx context.Context, opts ...CompilerOption) (*Compiler, error) {
 		o(&cfg)
 	}
 
+	overlay, err := newOverlayIndex(cfg.overlays)
+	if err != nil {
+		return nil, err
+	}
+
 	rtConfig := wazero.NewRuntimeConfig().WithCloseOnContextDone(true)
 
 	var cache wazero.CompilationCache
@@ -63,16 +80,24 @@ func New(ctx context.Context, opts ...CompilerOption) (*Compiler, error) {
 		return nil, fmt.Errorf("tecgonic: compiling WASM module: %w", err)
 	}
 
-	return &Compiler{
+	c := &Compiler{
 		runtime:  rt,
 		compiled: compiled,
 		config:   cfg,
 		cache:    cache,
-	}, nil
+		overlay:  overlay,
+	}
+	if cfg.poolSize > 0 && (cfg.defaultBundleDir != "" || cfg.defaultBundleFS != nil) {
+		c.pool = startPool(cfg.poolSize, poolRetryDelay, c.newPooledInstance)
+	}
+	return c, nil
 }
 
 // Close releases the WASM runtime and all associated resources.
 func (c *Compiler) Close(ctx context.Context) error {
+	if c.pool != nil {
+		c.pool.close(ctx)
+	}
 	err := c.runtime.Close(ctx)
 	if c.cache != nil {
 		if cacheErr := c.cache.Close(ctx); err == nil {
@@ -86,12 +111,11 @@ func (c *Compiler) Close(ctx context.Context) error {
 // This must be called once after extracting a bundle before compilations can succeed.
 // If latex.fmt already exists in bundleDir, this is a no-op.
 func (c *Compiler) GenerateFormat(ctx context.Context, bundleDir string, opts ...GenerateFormatOption) error {
-	var fmtCfg generateFormatConfig
-	for _, o := range opts {
-		o(&fmtCfg)
-	}
 	if bundleDir == "" {
-		return fmt.Errorf("tecgonic: no bundle directory specified")
+		return fmt.Errorf("tecgonic: no bundle directory specified: %w", ErrBundleMissing)
+	}
+	if info, err := os.Stat(bundleDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("tecgonic: bundle directory %s: %w", bundleDir, ErrBundleMissing)
 	}
 
 	// Skip if format file already exists
@@ -99,176 +123,216 @@ func (c *Compiler) GenerateFormat(ctx context.Context, bundleDir string, opts ..
 		return nil
 	}
 
-	tmpDir, err := os.MkdirTemp("", "tecgonic-fmt-*")
+	fmtData, err := c.generateFormat(ctx, mounts{bundleDir: bundleDir}, opts)
 	if err != nil {
-		return fmt.Errorf("tecgonic: creating temp dir: %w", err)
+		return err
 	}
-	defer func() { _ = os.RemoveAll(tmpDir) }()
-
-	inputDir := filepath.Join(tmpDir, "input")
-	outputDir := filepath.Join(tmpDir, "output")
-	cacheDir := filepath.Join(tmpDir, "cache")
-	fontsDir := filepath.Join(tmpDir, "fonts")
 
-	for _, dir := range []string{inputDir, outputDir, cacheDir, fontsDir} {
-		if err := os.MkdirAll(dir, 0o755); err != nil {
-			return fmt.Errorf("tecgonic: creating directory %s: %w", dir, err)
-		}
+	if err := os.WriteFile(filepath.Join(bundleDir, "latex.fmt"), fmtData, 0o644); err != nil {
+		return fmt.Errorf("tecgonic: writing format file to bundle dir: %w", err)
 	}
 
-	var stderrBuf bytes.Buffer
-	var stderrWriter io.Writer = &stderrBuf
-	if fmtCfg.stderr != nil {
-		stderrWriter = io.MultiWriter(&stderrBuf, fmtCfg.stderr)
+	return nil
+}
+
+// GenerateFormatFS generates the LaTeX format file for a bundle served from
+// bundle and writes it to w. Since bundle is read-only, the caller must make
+// the result available as latex.fmt at the root of the bundle passed to
+// WithBundleFS, e.g. by generating it at build time and embedding it next to
+// the bundle files.
+func (c *Compiler) GenerateFormatFS(ctx context.Context, bundle fs.FS, w io.Writer, opts ...GenerateFormatOption) error {
+	if bundle == nil {
+		return fmt.Errorf("tecgonic: no bundle filesystem specified: %w", ErrBundleMissing)
+	}
+	fmtData, err := c.generateFormat(ctx, mounts{bundleFS: bundle}, opts)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(fmtData); err != nil {
+		return fmt.Errorf("tecgonic: writing format file: %w", err)
 	}
+	return nil
+}
 
-	fsConfig := wazero.NewFSConfig().
-		WithDirMount(inputDir, "/input").
-		WithDirMount(outputDir, "/output").
-		WithReadOnlyDirMount(bundleDir, "/bundle").
-		WithDirMount(fontsDir, "/fonts").
-		WithDirMount(cacheDir, "/cache")
+// generateFormat runs format generation against the bundle in m and returns
+// the generated format file.
+func (c *Compiler) generateFormat(ctx context.Context, m mounts, opts []GenerateFormatOption) ([]byte, error) {
+	var fmtCfg generateFormatConfig
+	for _, o := range opts {
+		o(&fmtCfg)
+	}
 
-	modConfig := wazero.NewModuleConfig().
-		WithName("").
-		WithStdout(io.Discard).
-		WithStderr(stderrWriter).
-		WithFSConfig(fsConfig).
-		WithEnv("TECTONIC_FONT_DIR", "/fonts").
-		WithEnv("TECTONIC_CACHE_DIR", "/cache")
+	ws, err := newWorkspace(false, "tecgonic-fmt-*")
+	if err != nil {
+		return nil, err
+	}
 
-	mod, err := c.runtime.InstantiateModule(ctx, c.compiled, modConfig)
+	inst, err := c.instantiate(ctx, c.compiled, ws, m)
 	if err != nil {
-		return fmt.Errorf("tecgonic: instantiating module for format generation: %w", err)
+		ws.close()
+		return nil, err
 	}
-	defer func() { _ = mod.Close(ctx) }()
+	defer inst.close(ctx)
+	mod := inst.mod
+
+	var stderrBuf bytes.Buffer
+	var stderrWriter io.Writer = &stderrBuf
+	if fmtCfg.stderr != nil {
+		stderrWriter = io.MultiWriter(&stderrBuf, fmtCfg.stderr)
+	}
+	inst.stderr.set(stderrWriter)
 
 	fn := mod.ExportedFunction("tectonic_generate_format")
 	if fn == nil {
-		return fmt.Errorf("tecgonic: exported function tectonic_generate_format not found (rebuild WASM module with updated upstream)")
+		return nil, fmt.Errorf("tecgonic: exported function tectonic_generate_format not found (rebuild WASM module with updated upstream)")
 	}
 
 	results, callErr := fn.Call(ctx)
 	if callErr != nil {
-		return &CompileError{
-			ExitCode: 2,
-			Logs:     stderrBuf.String(),
-			WasmErr:  callErr,
-		}
+		return nil, runError(ctx, 0, callErr, stderrBuf.String(), ParseLog(stderrBuf.String()))
 	}
 	if len(results) > 0 && results[0] != 0 {
-		return &CompileError{
-			ExitCode: int32(results[0]),
-			Logs:     stderrBuf.String(),
-		}
+		return nil, runError(ctx, int32(results[0]), nil, stderrBuf.String(), ParseLog(stderrBuf.String()))
 	}
 
-	// Find the generated format file in cache and copy to bundle dir
-	fmtPath := filepath.Join(cacheDir, "latex.fmt")
-	if _, err := os.Stat(fmtPath); err != nil {
+	// Find the generated format file in cache
+	fmtData, err := ws.readFile("cache", "latex.fmt")
+	if err != nil {
 		// Search for any .fmt file
-		entries, _ := os.ReadDir(cacheDir)
-		found := false
-		for _, e := range entries {
-			if filepath.Ext(e.Name()) == ".fmt" {
-				fmtPath = filepath.Join(cacheDir, e.Name())
-				found = true
+		names, _ := ws.readDir("cache")
+		found := ""
+		for _, name := range names {
+			if filepath.Ext(name) == ".fmt" {
+				found = name
 				break
 			}
 		}
-		if !found {
-			return fmt.Errorf("tecgonic: no format file generated in cache (tectonic output: %s)", stderrBuf.String())
+		if found == "" {
+			return nil, fmt.Errorf("tecgonic: no format file generated in cache (tectonic output: %s)", stderrBuf.String())
+		}
+		if fmtData, err = ws.readFile("cache", found); err != nil {
+			return nil, fmt.Errorf("tecgonic: reading generated format file: %w", err)
 		}
 	}
+	return fmtData, nil
+}
 
-	fmtData, err := os.ReadFile(fmtPath)
+// Compile compiles the given LaTeX source to PDF.
+// Each call creates an isolated WASM instance with its own filesystem.
+func (c *Compiler) Compile(ctx context.Context, texSource []byte, opts ...CompileOption) ([]byte, error) {
+	res, err := c.compile(ctx, compileInput{source: texSource}, opts)
 	if err != nil {
-		return fmt.Errorf("tecgonic: reading generated format file: %w", err)
+		return nil, err
 	}
+	return res.PDF, nil
+}
 
-	if err := os.WriteFile(filepath.Join(bundleDir, "latex.fmt"), fmtData, 0o644); err != nil {
-		return fmt.Errorf("tecgonic: writing format file to bundle dir: %w", err)
+// CompileDetailed is like Compile but returns a CompileResult holding every
+// artifact of the run (PDF, TeX log, auxiliary files, stderr) together with
+// its duration and page count.
+func (c *Compiler) CompileDetailed(ctx context.Context, texSource []byte, opts ...CompileOption) (*CompileResult, error) {
+	return c.compile(ctx, compileInput{source: texSource, detailed: true}, opts)
+}
+
+// CompileFS compiles a multi-file LaTeX project to PDF. The whole of fsys is
+// mounted read-only as the engine's input directory, so \input, \include,
+// \includegraphics, \bibliography and local .sty/.cls files resolve against
+// its root just as they would when running tectonic from the project directory.
+//
+// main is the slash-separated path of the main TeX file within fsys. It is
+// exposed to the engine as input.tex, shadowing any file of that name at the
+// root of fsys.
+func (c *Compiler) CompileFS(ctx context.Context, fsys fs.FS, main string, opts ...CompileOption) ([]byte, error) {
+	if err := checkMain(fsys, main); err != nil {
+		return nil, err
+	}
+	res, err := c.compile(ctx, compileInput{fsys: &mainFS{FS: fsys, main: main}}, opts)
+	if err != nil {
+		return nil, err
 	}
+	return res.PDF, nil
+}
 
+// CompileFSDetailed is like CompileFS but returns a CompileResult. See
+// CompileDetailed.
+func (c *Compiler) CompileFSDetailed(ctx context.Context, fsys fs.FS, main string, opts ...CompileOption) (*CompileResult, error) {
+	if err := checkMain(fsys, main); err != nil {
+		return nil, err
+	}
+	return c.compile(ctx, compileInput{fsys: &mainFS{FS: fsys, main: main}, detailed: true}, opts)
+}
+
+// checkMain checks that main names a file in fsys.
+func checkMain(fsys fs.FS, main string) error {
+	if !fs.ValidPath(main) || main == "." {
+		return fmt.Errorf("tecgonic: invalid main file path %q", main)
+	}
+	info, err := fs.Stat(fsys, main)
+	if err != nil {
+		return fmt.Errorf("tecgonic: main file: %w", err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("tecgonic: main file %s is a directory", main)
+	}
 	return nil
 }
 
-// Compile compiles the given LaTeX source to PDF.
-// Each call creates an isolated WASM instance with its own filesystem.
-func (c *Compiler) Compile(ctx context.Context, texSource []byte, opts ...CompileOption) ([]byte, error) {
+// compileInput describes what is mounted at /input for a compilation: either a
+// single TeX source written to input.tex, or a caller-supplied project tree.
+// detailed is set when the caller returns every output file in the result.
+type compileInput struct {
+	source   []byte
+	fsys     fs.FS
+	detailed bool
+}
+
+// configure returns the configuration of a compilation: the Compiler's
+// defaults overridden by opts.
+func (c *Compiler) configure(opts []CompileOption) compileConfig {
 	cfg := compileConfig{
 		bundleDir: c.config.defaultBundleDir,
+		bundleFS:  c.config.defaultBundleFS,
 		fontsDir:  c.config.defaultFontsDir,
+		inMemory:  c.config.inMemory,
 	}
 	for _, o := range opts {
 		o(&cfg)
 	}
+	return cfg
+}
 
-	if cfg.bundleDir == "" {
-		return nil, fmt.Errorf("tecgonic: no bundle directory specified (use WithDefaultBundleDir or WithBundleDir)")
-	}
-
-	// Create isolated temp directories for this compilation
-	tmpDir, err := os.MkdirTemp("", "tecgonic-*")
-	if err != nil {
-		return nil, fmt.Errorf("tecgonic: creating temp dir: %w", err)
+func (c *Compiler) compile(ctx context.Context, in compileInput, opts []CompileOption) (*CompileResult, error) {
+	cfg := c.configure(opts)
+	if err := checkBundle(cfg.bundleDir, cfg.bundleFS); err != nil {
+		return nil, err
 	}
-	defer func() { _ = os.RemoveAll(tmpDir) }()
 
-	inputDir := filepath.Join(tmpDir, "input")
-	outputDir := filepath.Join(tmpDir, "output")
-	cacheDir := filepath.Join(tmpDir, "cache")
+	// Enforce resource limits through the context the engine runs under
+	runCtx, lim := newLimiter(ctx, cfg.limits)
+	defer lim.close()
 
-	for _, dir := range []string{inputDir, outputDir, cacheDir} {
-		if err := os.MkdirAll(dir, 0o755); err != nil {
-			return nil, fmt.Errorf("tecgonic: creating directory %s: %w", dir, err)
-		}
+	// Get a fresh module for this compilation, pre-warmed if possible
+	inst, err := c.acquire(runCtx, cfg, in, lim)
+	if err != nil {
+		return nil, err
 	}
+	defer inst.close(ctx)
+	ws, mod := inst.ws, inst.mod
 
-	// If no fonts dir specified, create an empty one
-	fontsDir := cfg.fontsDir
-	if fontsDir == "" {
-		fontsDir = filepath.Join(tmpDir, "fonts")
-		if err := os.MkdirAll(fontsDir, 0o755); err != nil {
-			return nil, fmt.Errorf("tecgonic: creating fonts dir: %w", err)
+	// Write TeX source to input directory, unless a project tree is mounted instead
+	if in.fsys == nil {
+		if err := ws.writeFile("input", "input.tex", in.source); err != nil {
+			return nil, fmt.Errorf("tecgonic: writing input.tex: %w", err)
 		}
 	}
 
-	// Write TeX source to input directory
-	texPath := filepath.Join(inputDir, "input.tex")
-	if err := os.WriteFile(texPath, texSource, 0o644); err != nil {
-		return nil, fmt.Errorf("tecgonic: writing input.tex: %w", err)
-	}
-
 	// Set up stderr capture
 	var stderrBuf bytes.Buffer
-	var stderrWriter io.Writer = &stderrBuf
+	stderrWriter := lim.wrapLog(&stderrBuf)
 	if cfg.stderr != nil {
-		stderrWriter = io.MultiWriter(&stderrBuf, cfg.stderr)
-	}
-
-	// Configure filesystem mounts
-	fsConfig := wazero.NewFSConfig().
-		WithDirMount(inputDir, "/input").
-		WithDirMount(outputDir, "/output").
-		WithReadOnlyDirMount(cfg.bundleDir, "/bundle").
-		WithDirMount(fontsDir, "/fonts").
-		WithDirMount(cacheDir, "/cache")
-
-	modConfig := wazero.NewModuleConfig().
-		WithName("").
-		WithStdout(io.Discard).
-		WithStderr(stderrWriter).
-		WithFSConfig(fsConfig).
-		WithEnv("TECTONIC_FONT_DIR", "/fonts").
-		WithEnv("TECTONIC_CACHE_DIR", "/cache")
-
-	// Instantiate a fresh module for this compilation
-	mod, err := c.runtime.InstantiateModule(ctx, c.compiled, modConfig)
-	if err != nil {
-		return nil, fmt.Errorf("tecgonic: instantiating module: %w", err)
+		stderrWriter = io.MultiWriter(stderrWriter, cfg.stderr)
 	}
-	defer func() { _ = mod.Close(ctx) }()
+	inst.stderr.set(stderrWriter)
 
 	// Call tectonic_compile_defaults
 	fn := mod.ExportedFunction("tectonic_compile_defaults")
@@ -276,30 +340,79 @@ func (c *Compiler) Compile(ctx context.Context, texSource []byte, opts ...Compil
 		return nil, fmt.Errorf("tecgonic: exported function tectonic_compile_defaults not found")
 	}
 
-	results, callErr := fn.Call(ctx)
+	start := time.Now()
+	results, callErr := fn.Call(runCtx)
+	duration := time.Since(start)
 
-	// Handle WASM trap (callErr != nil)
+	// Handle WASM trap or interruption (callErr != nil)
 	if callErr != nil {
-		return nil, &CompileError{
-			ExitCode: 2,
-			Logs:     stderrBuf.String(),
-			WasmErr:  callErr,
-		}
+		return nil, runError(runCtx, 0, callErr, stderrBuf.String(), diagnose(ws, stderrBuf.String()))
 	}
 
 	// Handle non-zero exit code
 	if len(results) > 0 && results[0] != 0 {
+		return nil, runError(runCtx, int32(results[0]), nil, stderrBuf.String(), diagnose(ws, stderrBuf.String()))
+	}
+
+	// Enforce limits on the finished output before reading it
+	if err := lim.checkOutput(ws); err != nil {
 		return nil, &CompileError{
-			ExitCode: int32(results[0]),
-			Logs:     stderrBuf.String(),
+			Logs:        stderrBuf.String(),
+			Cause:       err,
+			Diagnostics: diagnose(ws, stderrBuf.String()),
 		}
 	}
 
-	// Read the output PDF
-	pdfPath := filepath.Join(outputDir, "input.pdf")
+	res := &CompileResult{
+		Stderr:   stderrBuf.String(),
+		Duration: duration,
+		Fuel:     lim.fuelUsed(),
+	}
+	if in.detailed {
+		// Collect every file left in the output directory
+		names, err := ws.readDir("output")
+		if err != nil {
+			return nil, fmt.Errorf("tecgonic: reading output directory: %w", err)
+		}
+		res.Files = make(map[string][]byte, len(names))
+		for _, name := range names {
+			if name == "input.pdf" && cfg.output != nil {
+				continue
+			}
+			data, err := ws.readFile("output", name)
+			if err != nil {
+				return nil, fmt.Errorf("tecgonic: reading output file %s: %w", name, err)
+			}
+			res.Files[name] = data
+		}
+	}
+	if log, err := outputFile(ws, res.Files, "input.log"); err == nil {
+		res.Log = string(log)
+	}
+	if cfg.output == nil {
+		pdf, err := outputFile(ws, res.Files, "input.pdf")
+		if err != nil {
+			return nil, fmt.Errorf("tecgonic: reading output PDF: %w (tectonic output: %s)", err, stderrBuf.String())
+		}
+		res.PDF = pdf
+	}
+	res.Pages = countPages(res.Log, res.PDF)
+	res.Diagnostics = parseDiagnostics(res.Log, res.Stderr)
+
+	// In strict mode, selected warnings fail the compilation
+	if cfg.failOnWarnings {
+		if warnings := filterWarnings(res.Diagnostics, cfg.failCategories); len(warnings) > 0 {
+			return nil, &CompileError{
+				Logs:        res.Stderr,
+				Cause:       warningsError(warnings),
+				Diagnostics: res.Diagnostics,
+			}
+		}
+	}
 
+	// Stream the output PDF if requested
 	if cfg.output != nil {
-		f, err := os.Open(pdfPath)
+		f, err := ws.openFile("output", "input.pdf")
 		if err != nil {
 			return nil, fmt.Errorf("tecgonic: opening output PDF: %w (tectonic output: %s)", err, stderrBuf.String())
 		}
@@ -307,13 +420,132 @@ func (c *Compiler) Compile(ctx context.Context, texSource []byte, opts ...Compil
 		if _, err := io.Copy(cfg.output, f); err != nil {
 			return nil, fmt.Errorf("tecgonic: writing PDF to output: %w", err)
 		}
-		return nil, nil
 	}
 
-	pdfBytes, err := os.ReadFile(pdfPath)
+	return res, nil
+}
+
+// outputFile returns the output file name, taking it from files if the output
+// directory was already collected.
+func outputFile(ws *workspace, files map[string][]byte, name string) ([]byte, error) {
+	if files == nil {
+		return ws.readFile("output", name)
+	}
+	data, ok := files[name]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return data, nil
+}
+
+// acquire returns the instance a compilation runs on: a pre-warmed one from
+// the pool when the compilation's options allow it, or a new one created with
+// the compilation's own mounts and limits.
+func (c *Compiler) acquire(ctx context.Context, cfg compileConfig, in compileInput, lim *limiter) (*instance, error) {
+	if c.pool != nil {
+		if !c.poolable(cfg, in) {
+			c.pool.bypassed.Add(1)
+		} else if inst := c.pool.get(); inst != nil {
+			return inst, nil
+		}
+	}
+
+	// Fuel budgets need the instrumented module
+	compiled := c.compiled
+	if cfg.limits.fuelBudget > 0 {
+		var err error
+		if compiled, err = c.meteredModule(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	overlay, err := c.overlays(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create isolated directories for this compilation
+	ws, err := newWorkspace(cfg.inMemory, "tecgonic-*")
 	if err != nil {
-		return nil, fmt.Errorf("tecgonic: reading output PDF: %w (tectonic output: %s)", err, stderrBuf.String())
+		return nil, err
+	}
+	ws.wrapOutput = lim.wrapOutput
+
+	inst, err := c.instantiate(ctx, compiled, ws, mounts{
+		input:     in.fsys,
+		fontsDir:  cfg.fontsDir,
+		bundleDir: cfg.bundleDir,
+		bundleFS:  cfg.bundleFS,
+		overlay:   overlay,
+	})
+	if err != nil {
+		ws.close()
+		return nil, err
+	}
+	return inst, nil
+}
+
+// checkBundle verifies that a compilation has a bundle, served from fsys if
+// non-nil and from dir otherwise, and that the bundle holds a format file.
+func checkBundle(dir string, fsys fs.FS) error {
+	if fsys != nil {
+		if _, err := fs.Stat(fsys, "latex.fmt"); err != nil {
+			return fmt.Errorf("tecgonic: no latex.fmt in bundle filesystem (use GenerateFormatFS): %w", ErrNoFormat)
+		}
+		return nil
 	}
+	if dir == "" {
+		return fmt.Errorf("tecgonic: no bundle specified (use WithDefaultBundleDir, WithDefaultBundleFS, WithBundleDir or WithBundleFS): %w", ErrBundleMissing)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return fmt.Errorf("tecgonic: bundle directory %s: %w", dir, ErrBundleMissing)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "latex.fmt")); err != nil {
+		return fmt.Errorf("tecgonic: no latex.fmt in %s (use GenerateFormat): %w", dir, ErrNoFormat)
+	}
+	return nil
+}
+
+// warningsError summarises the warnings that failed a strict compilation.
+func warningsError(warnings []Diagnostic) error {
+	const maxListed = 5
+	msgs := make([]string, 0, maxListed)
+	for i, w := range warnings {
+		if i == maxListed {
+			msgs = append(msgs, fmt.Sprintf("and %d more", len(warnings)-maxListed))
+			break
+		}
+		msgs = append(msgs, w.String())
+	}
+	return fmt.Errorf("%w: %s", ErrWarnings, strings.Join(msgs, "; "))
+}
 
-	return pdfBytes, nil
+// diagnose parses the diagnostics of a failed run, reading its TeX log from
+// the output directory.
+func diagnose(ws *workspace, stderr string) []Diagnostic {
+	log, _ := ws.readFile("output", "input.log")
+	return parseDiagnostics(string(log), stderr)
+}
+
+// parseDiagnostics parses the diagnostics of a run from its TeX log when the
+// engine kept one, and from tectonic's stderr otherwise.
+func parseDiagnostics(log, stderr string) []Diagnostic {
+	if log != "" {
+		return ParseLog(log)
+	}
+	return ParseLog(stderr)
+}
+
+// mainFS exposes a project's main TeX file as input.tex, the fixed name the
+// engine compiles, while passing every other path through unchanged.
+type mainFS struct {
+	fs.FS
+	main string
+}
+
+func (m *mainFS) Open(name string) (fs.File, error) {
+	if name == "input.tex" {
+		return m.FS.Open(m.main)
+	}
+	return m.FS.Open(name)
 }
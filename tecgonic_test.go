@@ -5,9 +5,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 func bundleDir(t *testing.T) string {
@@ -105,6 +110,256 @@ func TestCompileError(t *testing.T) {
 	t.Logf("Got expected CompileError (exit code %d): %s", compErr.ExitCode, compErr.Logs)
 }
 
+func TestCompileDetailed(t *testing.T) {
+	dir := bundleDir(t)
+	ctx := context.Background()
+
+	c, err := New(ctx, WithDefaultBundleDir(dir))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer func() { _ = c.Close(ctx) }()
+
+	tex := []byte(`\documentclass{article}
+\begin{document}
+\tableofcontents
+\section{One}
+First page.
+\newpage
+\section{Two}
+Second page.
+\end{document}
+`)
+
+	res, err := c.CompileDetailed(ctx, tex)
+	if err != nil {
+		t.Fatalf("CompileDetailed: %v", err)
+	}
+	if !bytes.HasPrefix(res.PDF, []byte("%PDF-")) {
+		t.Fatalf("PDF does not look like a PDF (got %d bytes)", len(res.PDF))
+	}
+	if _, ok := res.Files["input.pdf"]; !ok {
+		t.Errorf("Files does not contain input.pdf (got %d files)", len(res.Files))
+	}
+	for _, name := range []string{"input.aux", "input.toc"} {
+		if _, ok := res.Files[name]; !ok {
+			t.Errorf("Files does not contain %s (got %d files)", name, len(res.Files))
+		}
+	}
+	if res.Pages != 2 {
+		t.Errorf("Pages = %d, want 2", res.Pages)
+	}
+	if !strings.Contains(res.Log, "Output written on") {
+		t.Errorf("Log does not look like a TeX log (got %d bytes)", len(res.Log))
+	}
+	if res.Duration <= 0 {
+		t.Errorf("Duration = %v, want > 0", res.Duration)
+	}
+}
+
+func TestCompileFailOnWarnings(t *testing.T) {
+	dir := bundleDir(t)
+	ctx := context.Background()
+
+	c, err := New(ctx, WithDefaultBundleDir(dir))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer func() { _ = c.Close(ctx) }()
+
+	tex := []byte(`\documentclass{article}
+\begin{document}
+See section~\ref{sec:missing}.
+\end{document}
+`)
+
+	res, err := c.CompileDetailed(ctx, tex)
+	if err != nil {
+		t.Fatalf("CompileDetailed: %v", err)
+	}
+	if len(res.Warnings()) == 0 {
+		t.Errorf("expected warnings for an undefined reference, got none (stderr: %s)", res.Stderr)
+	}
+
+	_, err = c.Compile(ctx, tex, WithFailOnWarnings(CategoryUndefinedReference))
+	if !errors.Is(err, ErrWarnings) {
+		t.Fatalf("expected ErrWarnings, got %v", err)
+	}
+
+	if _, err := c.Compile(ctx, tex, WithFailOnWarnings(CategoryMissingCharacter)); err != nil {
+		t.Fatalf("Compile with unrelated strict category: %v", err)
+	}
+}
+
+func TestCompileFuelBudget(t *testing.T) {
+	dir := bundleDir(t)
+	ctx := context.Background()
+
+	c, err := New(ctx, WithDefaultBundleDir(dir))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer func() { _ = c.Close(ctx) }()
+
+	tex := []byte(`\documentclass{article}
+\begin{document}
+Hello, fuel.
+\end{document}
+`)
+
+	res, err := c.CompileDetailed(ctx, tex, WithFuelBudget(1<<62))
+	if err != nil {
+		t.Fatalf("CompileDetailed: %v", err)
+	}
+	if res.Fuel == 0 {
+		t.Fatal("metered compilation reported no fuel used")
+	}
+
+	again, err := c.CompileDetailed(ctx, tex, WithFuelBudget(res.Fuel))
+	if err != nil {
+		t.Fatalf("CompileDetailed with exact budget: %v", err)
+	}
+	if again.Fuel != res.Fuel {
+		t.Errorf("fuel not deterministic: %d then %d", res.Fuel, again.Fuel)
+	}
+
+	_, err = c.Compile(ctx, tex, WithFuelBudget(res.Fuel/2))
+	if !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("expected ErrBudgetExceeded, got %v", err)
+	}
+}
+
+func TestCompileInstancePool(t *testing.T) {
+	dir := bundleDir(t)
+	ctx := context.Background()
+
+	c, err := New(ctx, WithDefaultBundleDir(dir), WithInstancePool(1))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer func() { _ = c.Close(ctx) }()
+
+	deadline := time.Now().Add(time.Minute)
+	for c.PoolStats().Idle == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tex := []byte(`\documentclass{article}
+\begin{document}
+Pooled.
+\end{document}
+`)
+	if _, err := c.Compile(ctx, tex); err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if _, err := c.Compile(ctx, tex, WithFuelBudget(1<<62)); err != nil {
+		t.Fatalf("Compile with fuel budget: %v", err)
+	}
+
+	stats := c.PoolStats()
+	if stats.Hits != 1 || stats.Bypassed != 1 {
+		t.Errorf("stats = %+v, want 1 hit and 1 bypass", stats)
+	}
+}
+
+func TestCompileFS(t *testing.T) {
+	dir := bundleDir(t)
+	ctx := context.Background()
+
+	c, err := New(ctx, WithDefaultBundleDir(dir))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer func() { _ = c.Close(ctx) }()
+
+	project := fstest.MapFS{
+		"report.tex": {Data: []byte(`\documentclass{article}
+\usepackage{local}
+\begin{document}
+\input{chapters/one}
+\end{document}
+`)},
+		"local.sty":        {Data: []byte(`\newcommand{\greeting}{Hello from a local package}`)},
+		"chapters/one.tex": {Data: []byte(`\greeting, chapter one.`)},
+	}
+
+	var stderr bytes.Buffer
+	pdf, err := c.CompileFS(ctx, project, "report.tex", WithStderr(&stderr))
+	if err != nil {
+		t.Fatalf("CompileFS: %v\nstderr: %s", err, stderr.String())
+	}
+	if !bytes.HasPrefix(pdf, []byte("%PDF-")) {
+		t.Fatalf("output does not look like a PDF (got %d bytes)", len(pdf))
+	}
+}
+
+func TestCompileInMemory(t *testing.T) {
+	dir := bundleDir(t)
+	ctx := context.Background()
+
+	c, err := New(ctx, WithDefaultBundleDir(dir))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer func() { _ = c.Close(ctx) }()
+
+	tex := []byte(`\documentclass{article}
+\begin{document}
+Hello from memory.
+\end{document}
+`)
+
+	var stderr bytes.Buffer
+	pdf, err := c.Compile(ctx, tex, WithInMemoryFS(), WithStderr(&stderr))
+	if err != nil {
+		t.Fatalf("Compile: %v\nstderr: %s", err, stderr.String())
+	}
+	if !bytes.HasPrefix(pdf, []byte("%PDF-")) {
+		t.Fatalf("output does not look like a PDF (got %d bytes)", len(pdf))
+	}
+}
+
+func TestCompileFSInvalidMain(t *testing.T) {
+	ctx := context.Background()
+
+	c, err := New(ctx)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer func() { _ = c.Close(ctx) }()
+
+	project := fstest.MapFS{
+		"main.tex":       {Data: []byte(`\documentclass{article}`)},
+		"chapters/a.tex": {Data: []byte(`A`)},
+	}
+
+	for _, main := range []string{"", "/main.tex", "../main.tex", "missing.tex", "chapters"} {
+		if _, err := c.CompileFS(ctx, project, main); err == nil {
+			t.Errorf("CompileFS(%q): expected error, got nil", main)
+		}
+	}
+}
+
+func TestMainFS(t *testing.T) {
+	project := fstest.MapFS{
+		"src/main.tex": {Data: []byte("main")},
+		"input.tex":    {Data: []byte("shadowed")},
+		"fig.png":      {Data: []byte("png")},
+	}
+	fsys := &mainFS{FS: project, main: "src/main.tex"}
+
+	got, err := fs.ReadFile(fsys, "input.tex")
+	if err != nil {
+		t.Fatalf("ReadFile(input.tex): %v", err)
+	}
+	if string(got) != "main" {
+		t.Errorf("input.tex = %q, want %q", got, "main")
+	}
+	if got, err := fs.ReadFile(fsys, "fig.png"); err != nil || string(got) != "png" {
+		t.Errorf("ReadFile(fig.png) = %q, %v", got, err)
+	}
+}
+
 func TestGenerateFormat(t *testing.T) {
 	dir := bundleDir(t)
 	ctx := context.Background()
@@ -146,10 +401,116 @@ Hello
 	if err == nil {
 		t.Fatal("expected error when no bundle dir set, got nil")
 	}
+	if !errors.Is(err, ErrBundleMissing) {
+		t.Errorf("expected ErrBundleMissing, got %v", err)
+	}
 
 	t.Logf("Got expected error: %v", err)
 }
 
+func TestNoFormatInBundleFS(t *testing.T) {
+	ctx := context.Background()
+
+	c, err := New(ctx, WithDefaultBundleFS(fstest.MapFS{
+		"article.cls": &fstest.MapFile{Data: []byte("%")},
+	}))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer func() { _ = c.Close(ctx) }()
+
+	_, err = c.Compile(ctx, []byte(`\documentclass{article}`))
+	if !errors.Is(err, ErrNoFormat) {
+		t.Errorf("expected ErrNoFormat, got %v", err)
+	}
+
+	// A per-compile bundle directory replaces the default filesystem.
+	_, err = c.Compile(ctx, []byte(`\documentclass{article}`), WithBundleDir(""))
+	if !errors.Is(err, ErrBundleMissing) {
+		t.Errorf("expected ErrBundleMissing, got %v", err)
+	}
+}
+
+func TestCompileBundleFS(t *testing.T) {
+	dir := bundleDir(t)
+	ctx := context.Background()
+
+	c, err := New(ctx, WithDefaultBundleFS(os.DirFS(dir)))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer func() { _ = c.Close(ctx) }()
+
+	pdf, err := c.Compile(ctx, []byte(`\documentclass{article}
+\begin{document}
+Bundle from fs.FS.
+\end{document}
+`))
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if !bytes.HasPrefix(pdf, []byte("%PDF")) {
+		t.Errorf("output does not look like a PDF")
+	}
+}
+
+func TestCompileWithBundle(t *testing.T) {
+	dir := bundleDir(t)
+	ctx := context.Background()
+
+	b, err := OpenBundle(dir)
+	if err != nil {
+		t.Fatalf("OpenBundle: %v", err)
+	}
+	defer func() { _ = b.Close() }()
+	if !b.HasFormat() {
+		t.Fatalf("bundle %s has no format", b)
+	}
+
+	c, err := New(ctx, WithDefaultBundle(b))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer func() { _ = c.Close(ctx) }()
+
+	pdf, err := c.Compile(ctx, []byte(`\documentclass{article}
+\begin{document}
+Bundle handle.
+\end{document}
+`), WithBundle(b))
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if !bytes.HasPrefix(pdf, []byte("%PDF")) {
+		t.Errorf("output does not look like a PDF")
+	}
+}
+
+func TestPreflightBundle(t *testing.T) {
+	dir := bundleDir(t)
+	ctx := context.Background()
+
+	c, err := New(ctx, WithDefaultBundleDir(dir))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer func() { _ = c.Close(ctx) }()
+
+	report, err := c.Preflight(ctx, []byte(`\documentclass{article}
+\usepackage{amsmath}
+\usepackage{nonexistentpackage}
+\begin{document}
+Preflight.
+\end{document}
+`))
+	if !errors.Is(err, ErrMissingFile) {
+		t.Fatalf("Preflight = %v, want ErrMissingFile", err)
+	}
+	if len(report.Missing) != 1 || report.Missing[0].Name != "nonexistentpackage" {
+		t.Errorf("missing = %v, want only nonexistentpackage", report.Missing)
+	}
+}
+
 func TestCompileConcurrent(t *testing.T) {
 	dir := bundleDir(t)
 	ctx := context.Background()
@@ -212,6 +573,9 @@ Hello
 	if err == nil {
 		t.Fatal("expected error from cancelled context, got nil")
 	}
+	if !errors.Is(err, ErrCanceled) {
+		t.Errorf("expected ErrCanceled, got %v", err)
+	}
 	t.Logf("Got expected error: %v", err)
 }
 
@@ -247,3 +611,36 @@ func BenchmarkNew(b *testing.B) {
 		}
 	})
 }
+
+func TestCompileWithOverlay(t *testing.T) {
+	dir := bundleDir(t)
+	ctx := context.Background()
+
+	styles := t.TempDir()
+	sty := filepath.Join(styles, "tex", "latex", "corp")
+	if err := os.MkdirAll(sty, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sty, "corp.sty"), []byte(`\newcommand{\corpname}{ACME}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New(ctx, WithDefaultBundleDir(dir))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer func() { _ = c.Close(ctx) }()
+
+	pdf, err := c.Compile(ctx, []byte(`\documentclass{article}
+\usepackage{corp}
+\begin{document}
+Made by \corpname.
+\end{document}
+`), WithIncludeDirs(styles))
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if !bytes.HasPrefix(pdf, []byte("%PDF")) {
+		t.Errorf("output does not look like a PDF")
+	}
+}
@@ -0,0 +1,274 @@
+package tecgonic
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TTB is a Tectonic bundle in the newer "ttbv1" format served directly from
+// the bundle file. A TTB file is a header, the individually gzipped bundle
+// files and a gzipped index locating them. Files are served flat at the root
+// by base name, as PrepareBundle extracts them.
+//
+// Like ITar, TTB implements fs.FS, is safe for concurrent use and serves the
+// format file from FormatPath as latex.fmt.
+type TTB struct {
+	archive
+	digest [32]byte
+}
+
+// BundleArchive is a bundle served from a single archive file: an *ITar or a
+// *TTB.
+type BundleArchive interface {
+	fs.StatFS
+	io.Closer
+
+	// FormatPath returns the path of the format file served as latex.fmt.
+	FormatPath() string
+}
+
+const (
+	ttbHeaderSize = 70
+	ttbVersion    = 1
+)
+
+// ttbSignature starts every TTB bundle.
+var ttbSignature = []byte("tectonicbundle")
+
+// ttbHeader is the fixed-size header at the start of a TTB file.
+type ttbHeader struct {
+	indexStart   uint64
+	indexGzipLen uint32
+	indexRealLen uint32
+	digest       [32]byte
+}
+
+// OpenBundleArchive opens the bundle file at path, detecting from its header
+// whether it is a TTB or an itar bundle.
+func OpenBundleArchive(path string) (BundleArchive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("tecgonic: opening bundle: %w", err)
+	}
+	header := make([]byte, 512)
+	n, err := io.ReadFull(f, header)
+	_ = f.Close()
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("tecgonic: reading bundle header: %w", err)
+	}
+	switch {
+	case isTTB(header[:n]):
+		return OpenTTB(path)
+	case isTar(header[:n]):
+		return OpenITar(path)
+	default:
+		return nil, fmt.Errorf("tecgonic: %s: unrecognized bundle format", path)
+	}
+}
+
+// isTTB reports whether header starts a TTB bundle.
+func isTTB(header []byte) bool {
+	return bytes.HasPrefix(header, ttbSignature)
+}
+
+// isTar reports whether header starts a POSIX or GNU tar archive.
+func isTar(header []byte) bool {
+	return len(header) >= 262 && bytes.Equal(header[257:262], []byte("ustar"))
+}
+
+// OpenTTB opens the TTB bundle at path, e.g. one saved with DownloadBundle.
+func OpenTTB(path string) (*TTB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("tecgonic: opening TTB bundle: %w", err)
+	}
+	b, err := newTTB(f, path)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("tecgonic: opening TTB bundle: %w", err)
+	}
+	return b, nil
+}
+
+func newTTB(f *os.File, path string) (*TTB, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]byte, ttbHeaderSize)
+	if _, err := f.ReadAt(raw, 0); err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	h, err := parseTTBHeader(raw)
+	if err != nil {
+		return nil, err
+	}
+	if int64(h.indexStart)+int64(h.indexGzipLen) > info.Size() {
+		return nil, errors.New("index extends past end of file (truncated download?)")
+	}
+	gr, err := gzip.NewReader(io.NewSectionReader(f, int64(h.indexStart), int64(h.indexGzipLen)))
+	if err != nil {
+		return nil, fmt.Errorf("reading index: %w", err)
+	}
+	defer func() { _ = gr.Close() }()
+	entries, err := parseTTBIndex(gr)
+	if err != nil {
+		return nil, fmt.Errorf("reading index: %w", err)
+	}
+	return &TTB{
+		archive: archive{f: f, path: path, modTime: info.ModTime(), entries: entries},
+		digest:  h.digest,
+	}, nil
+}
+
+// Digest returns the bundle's SHA-256 content digest recorded in its header,
+// in hex.
+func (b *TTB) Digest() string {
+	return hex.EncodeToString(b.digest[:])
+}
+
+func parseTTBHeader(raw []byte) (ttbHeader, error) {
+	var h ttbHeader
+	if len(raw) < ttbHeaderSize || !isTTB(raw) {
+		return h, errors.New("not a TTB bundle")
+	}
+	if v := binary.LittleEndian.Uint32(raw[14:18]); v != ttbVersion {
+		return h, fmt.Errorf("unsupported TTB version %d", v)
+	}
+	h.indexStart = binary.LittleEndian.Uint64(raw[18:26])
+	h.indexGzipLen = binary.LittleEndian.Uint32(raw[26:30])
+	h.indexRealLen = binary.LittleEndian.Uint32(raw[30:34])
+	copy(h.digest[:], raw[34:66])
+	return h, nil
+}
+
+// parseTTBIndex reads the [FILELIST] section of a TTB index. Each of its lines
+// is "start gzip_len real_len hash path"; other sections, such as the search
+// paths, are skipped. When several files share a base name, the first wins.
+func parseTTBIndex(r io.Reader) (map[string]itarEntry, error) {
+	entries := make(map[string]itarEntry)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	inFileList := false
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			inFileList = line == "[FILELIST]"
+			continue
+		}
+		if !inFileList {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 5)
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("malformed file list line %q", line)
+		}
+		var nums [3]int64
+		for i := range nums {
+			n, err := strconv.ParseInt(fields[i], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("malformed file list line %q", line)
+			}
+			nums[i] = n
+		}
+		name := path.Base(fields[4])
+		if _, dup := entries[name]; !dup {
+			entries[name] = itarEntry{offset: nums[0], length: nums[1], size: nums[2]}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("empty file list")
+	}
+	return entries, nil
+}
+
+// extractArchive writes every entry of a to destDir, decompressed, records
+// each in manifest and returns the number of files written. Entries are
+// extracted by a pool of workers, GOMAXPROCS of them if workers is not
+// positive.
+func extractArchive(a *archive, destDir string, workers int, progress ProgressFunc, manifest bundleManifest) (int, error) {
+	names := sortedNames(a.entries)
+	hashes := make([]string, len(names))
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	start := time.Now()
+	var (
+		next, done atomic.Int64
+		failed     atomic.Bool
+		mu         sync.Mutex // guards firstErr and progress calls
+		firstErr   error
+		wg         sync.WaitGroup
+	)
+	for range min(workers, len(names)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !failed.Load() {
+				i := int(next.Add(1) - 1)
+				if i >= len(names) {
+					return
+				}
+				e := a.entries[names[i]]
+				sum, err := extractEntry(io.NewSectionReader(a.f, e.offset, e.length), filepath.Join(destDir, names[i]))
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("extracting %s: %w", names[i], err)
+					}
+					mu.Unlock()
+					failed.Store(true)
+					return
+				}
+				hashes[i] = sum
+				if progress == nil {
+					done.Add(1)
+					continue
+				}
+				// Count under the lock so events arrive in order
+				mu.Lock()
+				n := done.Add(1)
+				progress(ProgressEvent{
+					Phase:      PhaseExtract,
+					Files:      int(n),
+					TotalFiles: len(names),
+					Entry:      names[i],
+					ETA:        eta(time.Since(start), n, int64(len(names))-n),
+				})
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return int(done.Load()), firstErr
+	}
+
+	for i, name := range names {
+		manifest[name] = hashes[i]
+	}
+	return len(names), nil
+}
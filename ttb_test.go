@@ -0,0 +1,160 @@
+package tecgonic
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+// writeTTB writes a ttbv1 bundle holding files, each stored under a nested
+// path as real bundles do, and returns its path.
+func writeTTB(t *testing.T, files map[string]string) string {
+	t.Helper()
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var body bytes.Buffer
+	var index bytes.Buffer
+	index.WriteString("[DEFAULTSEARCH]\nMAIN\n[SEARCH:MAIN]\n/tex//\n[FILELIST]\n")
+	for _, name := range names {
+		var gz bytes.Buffer
+		gw := gzip.NewWriter(&gz)
+		_, _ = gw.Write([]byte(files[name]))
+		_ = gw.Close()
+		start := ttbHeaderSize + body.Len()
+		body.Write(gz.Bytes())
+		fmt.Fprintf(&index, "%d %d %d nohash tex/latex/%s\n", start, gz.Len(), len(files[name]), name)
+	}
+	var gzIndex bytes.Buffer
+	gw := gzip.NewWriter(&gzIndex)
+	_, _ = gw.Write(index.Bytes())
+	_ = gw.Close()
+
+	header := make([]byte, ttbHeaderSize)
+	copy(header, ttbSignature)
+	binary.LittleEndian.PutUint32(header[14:], ttbVersion)
+	binary.LittleEndian.PutUint64(header[18:], uint64(ttbHeaderSize+body.Len()))
+	binary.LittleEndian.PutUint32(header[26:], uint32(gzIndex.Len()))
+	binary.LittleEndian.PutUint32(header[30:], uint32(index.Len()))
+	digest, _ := hex.DecodeString(contentDigest(files))
+	copy(header[34:], digest)
+
+	path := filepath.Join(t.TempDir(), "bundle.ttb")
+	data := slices.Concat(header, body.Bytes(), gzIndex.Bytes())
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTTB(t *testing.T) {
+	b, err := OpenTTB(writeTTB(t, itarFiles))
+	if err != nil {
+		t.Fatalf("OpenTTB: %v", err)
+	}
+	defer func() { _ = b.Close() }()
+
+	for name, want := range itarFiles {
+		got, err := fs.ReadFile(b, name)
+		if err != nil {
+			t.Fatalf("ReadFile(%s): %v", name, err)
+		}
+		if string(got) != want {
+			t.Errorf("ReadFile(%s) = %q, want %q", name, got, want)
+		}
+	}
+	if err := fstest.TestFS(b, "SVNREV", "article.cls", "SHA256SUM"); err != nil {
+		t.Error(err)
+	}
+	if len(b.Digest()) != 64 {
+		t.Errorf("Digest = %q, want 64 hex digits", b.Digest())
+	}
+}
+
+func TestOpenBundleArchive(t *testing.T) {
+	for _, tc := range []struct {
+		path string
+		want string
+	}{
+		{writeITar(t, itarFiles), "*tecgonic.ITar"},
+		{writeTTB(t, itarFiles), "*tecgonic.TTB"},
+	} {
+		b, err := OpenBundleArchive(tc.path)
+		if err != nil {
+			t.Fatalf("OpenBundleArchive(%s): %v", tc.want, err)
+		}
+		if got := fmt.Sprintf("%T", b); got != tc.want {
+			t.Errorf("OpenBundleArchive returned %s, want %s", got, tc.want)
+		}
+		_ = b.Close()
+	}
+
+	junk := filepath.Join(t.TempDir(), "junk")
+	_ = os.WriteFile(junk, []byte("not a bundle"), 0o644)
+	if _, err := OpenBundleArchive(junk); err == nil {
+		t.Error("OpenBundleArchive accepted a file that is not a bundle")
+	}
+}
+
+func TestParseTTBHeaderErrors(t *testing.T) {
+	header := make([]byte, ttbHeaderSize)
+	copy(header, ttbSignature)
+	binary.LittleEndian.PutUint32(header[14:], 2)
+	if _, err := parseTTBHeader(header); err == nil {
+		t.Error("parseTTBHeader accepted version 2")
+	}
+	if _, err := parseTTBHeader(header[:20]); err == nil {
+		t.Error("parseTTBHeader accepted a short header")
+	}
+}
+
+func TestPrepareBundleFormats(t *testing.T) {
+	files := genFiles(120)
+	files["SVNREV"] = "1\n"
+
+	for _, tc := range []struct {
+		name string
+		path string
+	}{
+		{"itar", writeITar(t, files)},
+		{"ttb", writeTTB(t, files)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := os.ReadFile(tc.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv := serveBundle(t, data, 0)
+
+			dest := t.TempDir()
+			if err := PrepareBundle(context.Background(), dest, srv.URL, false); err != nil {
+				t.Fatalf("PrepareBundle: %v", err)
+			}
+			got, err := os.ReadFile(filepath.Join(dest, "file007.sty"))
+			if err != nil || string(got) != files["file007.sty"] {
+				t.Errorf("file007.sty = %q, %v", got, err)
+			}
+			if _, err := os.Stat(filepath.Join(dest, "SHA256SUM")); err != nil && tc.name == "ttb" {
+				t.Errorf("SHA256SUM not written for TTB bundle: %v", err)
+			}
+			entries, _ := os.ReadDir(dest)
+			for _, e := range entries {
+				if filepath.Ext(e.Name()) == ".ttb" {
+					t.Errorf("temporary file %s left in bundle dir", e.Name())
+				}
+			}
+		})
+	}
+}
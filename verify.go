@@ -0,0 +1,255 @@
+package tecgonic
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ErrBundleCorrupt means a bundle directory failed verification. See
+// VerifyBundle.
+var ErrBundleCorrupt = errors.New("bundle failed verification")
+
+// manifestName is the file in which PrepareBundle records the SHA-256 of
+// every file it extracted.
+const manifestName = ".tecgonic-manifest"
+
+// manifestHeader starts every manifest file.
+const manifestHeader = "# tecgonic bundle manifest v1"
+
+// BundleReport is the result of verifying a bundle directory.
+type BundleReport struct {
+	// Digest is the content digest of the bundle, computed from the current
+	// contents of the files recorded in the manifest: the SHA-256 of each
+	// file's name, a NUL byte and the SHA-256 of its contents, for every
+	// file but SHA256SUM in name order. This is the digest Tectonic's bundle
+	// builder records in SHA256SUM.
+	Digest string
+
+	// SHA256SUM is the digest published with the bundle, read from its
+	// SHA256SUM file; empty if there is none.
+	SHA256SUM string
+
+	// Expected is the digest pinned with WithExpectedDigest, if any.
+	Expected string
+
+	Missing   []string // files in the manifest that no longer exist
+	Extra     []string // files not in the manifest
+	Corrupted []string // files whose contents differ from the manifest
+
+	// DigestMismatch is set if Digest does not match SHA256SUM or Expected.
+	DigestMismatch bool
+}
+
+// OK reports whether verification found no problem.
+func (r *BundleReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Extra) == 0 && len(r.Corrupted) == 0 && !r.DigestMismatch
+}
+
+type verifyConfig struct {
+	expectedDigest string
+}
+
+// VerifyOption configures a VerifyBundle call.
+type VerifyOption func(*verifyConfig)
+
+// WithExpectedDigest pins the content digest the bundle must have, in hex;
+// see BundleReport.Digest.
+func WithExpectedDigest(digest string) VerifyOption {
+	return func(c *verifyConfig) {
+		c.expectedDigest = digest
+	}
+}
+
+// VerifyBundle checks a bundle directory prepared with PrepareBundle against
+// the manifest PrepareBundle recorded: every file must still exist with the
+// same SHA-256, and no other files may have been added. latex.fmt is ignored,
+// since it is generated after extraction. The content digest computed from
+// the files must also match the bundle's SHA256SUM, if it has one, and the
+// digest pinned with WithExpectedDigest, so a corrupted or tampered download
+// is caught even though the manifest was recorded from it.
+//
+// The returned report lists every problem found. If there is any, the error
+// matches ErrBundleCorrupt.
+func VerifyBundle(dir string, opts ...VerifyOption) (*BundleReport, error) {
+	var cfg verifyConfig
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("tecgonic: %s has no manifest (prepare it again with PrepareBundle): %w", dir, ErrBundleCorrupt)
+		}
+		return nil, fmt.Errorf("tecgonic: reading bundle manifest: %w", err)
+	}
+	want, err := parseManifest(data)
+	if err != nil {
+		return nil, fmt.Errorf("tecgonic: reading bundle manifest: %w: %w", err, ErrBundleCorrupt)
+	}
+
+	report := &BundleReport{Expected: cfg.expectedDigest}
+	if sum, err := os.ReadFile(filepath.Join(dir, "SHA256SUM")); err == nil {
+		report.SHA256SUM = strings.TrimSpace(string(sum))
+	}
+
+	got := make(bundleManifest, len(want))
+	for name, hash := range want {
+		sum, err := hashFile(filepath.Join(dir, name))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			report.Missing = append(report.Missing, name)
+			continue
+		case err != nil:
+			return nil, fmt.Errorf("tecgonic: verifying %s: %w", name, err)
+		case sum != hash:
+			report.Corrupted = append(report.Corrupted, name)
+		}
+		got[name] = sum
+	}
+	report.Digest = got.digest()
+	for _, want := range []string{report.SHA256SUM, report.Expected} {
+		if want != "" && !strings.EqualFold(report.Digest, want) {
+			report.DigestMismatch = true
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("tecgonic: reading bundle dir: %w", err)
+	}
+	for _, e := range entries {
+		if _, ok := want[e.Name()]; !ok && !ignoredBundleFile(e.Name()) {
+			report.Extra = append(report.Extra, e.Name())
+		}
+	}
+
+	slices.Sort(report.Missing)
+	slices.Sort(report.Corrupted)
+	slices.Sort(report.Extra)
+	if !report.OK() {
+		return report, fmt.Errorf("tecgonic: %s: %w", report.summary(), ErrBundleCorrupt)
+	}
+	return report, nil
+}
+
+// summary describes the problems in a failed report.
+func (r *BundleReport) summary() string {
+	var parts []string
+	for _, p := range []struct {
+		what  string
+		names []string
+	}{{"missing", r.Missing}, {"extra", r.Extra}, {"corrupted", r.Corrupted}} {
+		if len(p.names) == 0 {
+			continue
+		}
+		s := fmt.Sprintf("%d %s (%s", len(p.names), p.what, strings.Join(p.names[:min(3, len(p.names))], ", "))
+		if len(p.names) > 3 {
+			s += ", ..."
+		}
+		parts = append(parts, s+")")
+	}
+	if r.DigestMismatch {
+		for _, p := range []struct{ what, digest string }{{"SHA256SUM", r.SHA256SUM}, {"expected digest", r.Expected}} {
+			if p.digest != "" && !strings.EqualFold(r.Digest, p.digest) {
+				parts = append(parts, fmt.Sprintf("content digest %s does not match %s %s", r.Digest, p.what, p.digest))
+			}
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// ignoredBundleFile reports whether name is a file tecgonic adds to a bundle
+// directory, rather than one extracted from the bundle.
+func ignoredBundleFile(name string) bool {
+	return name == manifestName || name == completeMarker || name == "latex.fmt"
+}
+
+// bundleManifest maps the name of each extracted file to its SHA-256, in hex.
+type bundleManifest map[string]string
+
+// add records the SHA-256 of a file's contents.
+func (m bundleManifest) add(name string, data []byte) {
+	sum := sha256.Sum256(data)
+	m[name] = hex.EncodeToString(sum[:])
+}
+
+// marshal writes the manifest in sha256sum format, sorted by name.
+func (m bundleManifest) marshal() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(manifestHeader + "\n")
+	buf.Write(m.body())
+	return buf.Bytes()
+}
+
+func (m bundleManifest) body() []byte {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s  %s\n", m[name], name)
+	}
+	return buf.Bytes()
+}
+
+// digest returns the content digest of the files in the manifest; see
+// BundleReport.Digest.
+func (m bundleManifest) digest() string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		if name != "SHA256SUM" {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	h := sha256.New()
+	for _, name := range names {
+		sum, _ := hex.DecodeString(m[name])
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+		h.Write(sum)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func parseManifest(data []byte) (bundleManifest, error) {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	if !sc.Scan() || sc.Text() != manifestHeader {
+		return nil, errors.New("unrecognized manifest format")
+	}
+	m := make(bundleManifest)
+	for sc.Scan() {
+		hash, name, ok := strings.Cut(sc.Text(), "  ")
+		if !ok || len(hash) != 64 || name == "" {
+			return nil, fmt.Errorf("malformed manifest line %q", sc.Text())
+		}
+		m[name] = hash
+	}
+	return m, sc.Err()
+}
+
+// hashFile returns the SHA-256 of a file's contents, in hex.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
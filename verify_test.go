@@ -0,0 +1,156 @@
+package tecgonic
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// contentDigest returns the content digest of a bundle holding files.
+func contentDigest(files map[string]string) string {
+	m := make(bundleManifest)
+	for name, data := range files {
+		m.add(name, []byte(data))
+	}
+	return m.digest()
+}
+
+func TestBundleDigest(t *testing.T) {
+	h := sha256.New()
+	for _, f := range []struct{ name, data string }{{"SVNREV", "1\n"}, {"a.sty", "x"}} {
+		sum := sha256.Sum256([]byte(f.data))
+		h.Write([]byte(f.name + "\x00"))
+		h.Write(sum[:])
+	}
+	want := hex.EncodeToString(h.Sum(nil))
+	if got := contentDigest(map[string]string{"a.sty": "x", "SVNREV": "1\n", "SHA256SUM": want}); got != want {
+		t.Errorf("digest = %s, want %s", got, want)
+	}
+}
+
+// prepareTestBundle extracts a generated itar bundle of 120 files into a new
+// directory with PrepareBundle and returns the directory.
+func prepareTestBundle(t *testing.T, opts ...PrepareBundleOption) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := PrepareBundle(context.Background(), dir, serveBundle(t, testBundle(t, 120), 0).URL, false, opts...); err != nil {
+		t.Fatalf("PrepareBundle: %v", err)
+	}
+	return dir
+}
+
+func TestVerifyBundle(t *testing.T) {
+	dir := prepareTestBundle(t)
+	sum, err := os.ReadFile(filepath.Join(dir, "SHA256SUM"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := strings.TrimSpace(string(sum))
+
+	report, err := VerifyBundle(dir, WithExpectedDigest(strings.ToUpper(digest)))
+	if err != nil {
+		t.Fatalf("VerifyBundle of a fresh bundle: %v", err)
+	}
+	if !report.OK() || report.SHA256SUM != digest || report.Digest != digest {
+		t.Errorf("report = %+v", report)
+	}
+
+	// A generated format file is not an extra file.
+	_ = os.WriteFile(filepath.Join(dir, "latex.fmt"), []byte("fmt"), 0o644)
+	if _, err := VerifyBundle(dir); err != nil {
+		t.Errorf("VerifyBundle with latex.fmt: %v", err)
+	}
+
+	_ = os.WriteFile(filepath.Join(dir, "file001.sty"), []byte("tampered"), 0o644)
+	_ = os.Remove(filepath.Join(dir, "file002.sty"))
+	_ = os.WriteFile(filepath.Join(dir, "stray.tex"), nil, 0o644)
+
+	report, err = VerifyBundle(dir, WithExpectedDigest("beef"))
+	if !errors.Is(err, ErrBundleCorrupt) {
+		t.Fatalf("expected ErrBundleCorrupt, got %v", err)
+	}
+	if !slices.Equal(report.Corrupted, []string{"file001.sty"}) ||
+		!slices.Equal(report.Missing, []string{"file002.sty"}) ||
+		!slices.Equal(report.Extra, []string{"stray.tex"}) ||
+		!report.DigestMismatch {
+		t.Errorf("report = %+v", report)
+	}
+
+	// An existing bundle is verified rather than trusted.
+	if err := PrepareBundle(context.Background(), dir, "http://invalid.invalid", false, WithVerify("")); !errors.Is(err, ErrBundleCorrupt) {
+		t.Errorf("PrepareBundle over a corrupted bundle: %v", err)
+	}
+}
+
+func TestVerifyBundleDigestStable(t *testing.T) {
+	a, err := VerifyBundle(prepareTestBundle(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := VerifyBundle(prepareTestBundle(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Digest != b.Digest {
+		t.Errorf("digests of identical bundles differ: %s, %s", a.Digest, b.Digest)
+	}
+}
+
+func TestVerifyBundleNoManifest(t *testing.T) {
+	if _, err := VerifyBundle(t.TempDir()); !errors.Is(err, ErrBundleCorrupt) {
+		t.Errorf("expected ErrBundleCorrupt, got %v", err)
+	}
+}
+
+func TestPrepareBundleExpectedDigest(t *testing.T) {
+	files := withDigest(genFiles(120))
+	srv := serveBundle(t, itarData(t, files), 0)
+
+	err := PrepareBundle(context.Background(), t.TempDir(), srv.URL, false, WithVerify("beef"))
+	if !errors.Is(err, ErrBundleCorrupt) {
+		t.Errorf("expected ErrBundleCorrupt for a pinned digest mismatch, got %v", err)
+	}
+	if err := PrepareBundle(context.Background(), t.TempDir(), srv.URL, false, WithVerify(strings.TrimSpace(files["SHA256SUM"]))); err != nil {
+		t.Errorf("PrepareBundle with the right pinned digest: %v", err)
+	}
+}
+
+// TestPrepareBundleTampered checks that a bundle whose contents do not match
+// its published digest is rejected, though the manifest is recorded from the
+// same download.
+func TestPrepareBundleTampered(t *testing.T) {
+	files := withDigest(genFiles(120))
+	files["file007.sty"] = "tampered"
+	srv := serveBundle(t, itarData(t, files), 0)
+
+	dest := filepath.Join(t.TempDir(), "bundle")
+	err := PrepareBundle(context.Background(), dest, srv.URL, false, WithVerify(""))
+	if !errors.Is(err, ErrBundleCorrupt) {
+		t.Errorf("expected ErrBundleCorrupt for a tampered bundle, got %v", err)
+	}
+	if _, err := os.Stat(dest); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("tampered bundle was installed: %v", err)
+	}
+}
+
+func TestInstallBundleTTBDigest(t *testing.T) {
+	files := withDigest(genFiles(120))
+	delete(files, "SHA256SUM")
+	path := writeTTB(t, files)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[40] ^= 0xff // corrupt the header digest
+
+	err = InstallBundle(context.Background(), filepath.Join(t.TempDir(), "bundle"), strings.NewReader(string(data)), WithVerify(""))
+	if !errors.Is(err, ErrBundleCorrupt) {
+		t.Errorf("expected ErrBundleCorrupt for a TTB header digest mismatch, got %v", err)
+	}
+}
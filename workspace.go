@@ -0,0 +1,152 @@
+package tecgonic
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/tetratelabs/wazero"
+	experimentalsys "github.com/tetratelabs/wazero/experimental/sys"
+	"github.com/tetratelabs/wazero/experimental/sysfs"
+)
+
+// workspace holds the writable directories of a single engine run (input,
+// output, cache and fonts), backed either by a temp directory on disk or by
+// in-memory filesystems.
+type workspace struct {
+	tmpDir string            // root of the on-disk directories, empty when in memory
+	mem    map[string]*memFS // in-memory directories by name, nil when on disk
+
+	// wrapOutput, if set, wraps the output directory's filesystem when it is
+	// mounted, e.g. to enforce resource limits.
+	wrapOutput func(experimentalsys.FS) experimentalsys.FS
+}
+
+// workspaceDirs are the writable directories every engine run gets, each
+// mounted at "/" + name.
+var workspaceDirs = []string{"input", "output", "cache", "fonts"}
+
+func newWorkspace(inMemory bool, pattern string) (*workspace, error) {
+	if inMemory {
+		mem := make(map[string]*memFS, len(workspaceDirs))
+		for _, name := range workspaceDirs {
+			mem[name] = newMemFS()
+		}
+		return &workspace{mem: mem}, nil
+	}
+
+	tmpDir, err := os.MkdirTemp("", pattern)
+	if err != nil {
+		return nil, fmt.Errorf("tecgonic: creating temp dir: %w", err)
+	}
+	for _, name := range workspaceDirs {
+		dir := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			_ = os.RemoveAll(tmpDir)
+			return nil, fmt.Errorf("tecgonic: creating directory %s: %w", dir, err)
+		}
+	}
+	return &workspace{tmpDir: tmpDir}, nil
+}
+
+// close removes any on-disk state.
+func (w *workspace) close() {
+	if w.tmpDir != "" {
+		_ = os.RemoveAll(w.tmpDir)
+	}
+}
+
+// mount adds the workspace directories to cfg. A non-nil input replaces the
+// workspace's own input directory, and a non-empty fontsDir replaces its
+// fonts directory.
+func (w *workspace) mount(cfg wazero.FSConfig, input fs.FS, fontsDir string) wazero.FSConfig {
+	for _, name := range workspaceDirs {
+		guestPath := "/" + name
+		switch {
+		case name == "input" && input != nil:
+			cfg = cfg.WithFSMount(input, guestPath)
+		case name == "fonts" && fontsDir != "":
+			cfg = cfg.WithDirMount(fontsDir, guestPath)
+		case name == "output" && w.wrapOutput != nil:
+			var fsys experimentalsys.FS = sysfs.DirFS(filepath.Join(w.tmpDir, name))
+			if w.mem != nil {
+				fsys = w.mem[name]
+			}
+			cfg = cfg.(sysfs.FSConfig).WithSysFSMount(w.wrapOutput(fsys), guestPath)
+		case w.mem != nil:
+			cfg = cfg.(sysfs.FSConfig).WithSysFSMount(w.mem[name], guestPath)
+		default:
+			cfg = cfg.WithDirMount(filepath.Join(w.tmpDir, name), guestPath)
+		}
+	}
+	return cfg
+}
+
+// writeFile creates the named file in one of the workspace directories.
+func (w *workspace) writeFile(dir, name string, data []byte) error {
+	if w.mem != nil {
+		return w.mem[dir].WriteFile(name, data)
+	}
+	return os.WriteFile(filepath.Join(w.tmpDir, dir, filepath.FromSlash(name)), data, 0o644)
+}
+
+// readFile returns the contents of the named file in one of the workspace
+// directories.
+func (w *workspace) readFile(dir, name string) ([]byte, error) {
+	if w.mem != nil {
+		return w.mem[dir].ReadFile(name)
+	}
+	return os.ReadFile(filepath.Join(w.tmpDir, dir, filepath.FromSlash(name)))
+}
+
+// fileSize returns the size in bytes of the named file in one of the
+// workspace directories.
+func (w *workspace) fileSize(dir, name string) (int64, error) {
+	if w.mem != nil {
+		st, errno := w.mem[dir].Stat(name)
+		if errno != 0 {
+			return 0, &fs.PathError{Op: "stat", Path: name, Err: errno}
+		}
+		return st.Size, nil
+	}
+	info, err := os.Stat(filepath.Join(w.tmpDir, dir, filepath.FromSlash(name)))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// openFile opens the named file in one of the workspace directories for
+// streaming.
+func (w *workspace) openFile(dir, name string) (io.ReadCloser, error) {
+	if w.mem != nil {
+		data, err := w.mem[dir].ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	return os.Open(filepath.Join(w.tmpDir, dir, filepath.FromSlash(name)))
+}
+
+// readDir returns the names of the regular files directly inside one of the
+// workspace directories.
+func (w *workspace) readDir(dir string) ([]string, error) {
+	if w.mem != nil {
+		return w.mem[dir].Files(), nil
+	}
+	var names []string
+	entries, err := os.ReadDir(filepath.Join(w.tmpDir, dir))
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}